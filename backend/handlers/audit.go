@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// Audited actions
+const (
+	auditUserActivate          = "user.activate"
+	auditUserDeactivate        = "user.deactivate"
+	auditUserRoleChange        = "user.role_change"
+	auditUserPointsAdjust      = "user.points_adjust"
+	auditUserPasswordReset     = "user.password_reset"
+	auditUserEmailVerification = "user.email_verification"
+	auditUserUnlock            = "user.unlock"
+	auditUserDelete            = "user.delete"
+	auditQuestCreate           = "quest.create"
+	auditQuestUpdate           = "quest.update"
+	auditQuestPublish          = "quest.publish"
+	auditQuestUnpublish        = "quest.unpublish"
+	auditQuestFeature          = "quest.feature"
+	auditQuestUnfeature        = "quest.unfeature"
+	auditQuestDelete           = "quest.delete"
+	auditChainCreate           = "chain.create"
+	auditChainUpdate           = "chain.update"
+	auditChainDelete           = "chain.delete"
+	auditSubmissionApprove     = "submission.approve"
+	auditSubmissionReject      = "submission.reject"
+	auditInviteCreate          = "invite.create"
+	auditInviteRevoke          = "invite.revoke"
+)
+
+// Types of record an audit event can target
+const (
+	auditTargetUser       = "user"
+	auditTargetQuest      = "quest"
+	auditTargetSubmission = "submission"
+	auditTargetInvite     = "invite"
+	auditTargetChain      = "chain"
+)
+
+// auditCSVBatchSize is how many events are loaded at a time for CSV export
+const auditCSVBatchSize = 500
+
+// AuditEventsResponse is a page of audit events matching the admin's filters
+type AuditEventsResponse struct {
+	Total  int64               `json:"total"` // Number of matching events
+	Events []models.AuditEvent `json:"events"`
+}
+
+// recordAudit saves an audit event for an action taken by the request's
+// user. before and after are stored as JSON snapshots; either may be nil.
+// Call it inside the action's transaction so the event is only kept if the
+// action is.
+func recordAudit(tx *gorm.DB, r *http.Request, action, targetType string, targetID uint, before, after interface{}) error {
+	event := models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         clientIP(r),
+	}
+	if actorID, ok := r.Context().Value("user_id").(uint); ok {
+		event.ActorID = &actorID
+	}
+
+	for _, snapshot := range []struct {
+		value interface{}
+		dest  *string
+	}{{before, &event.Before}, {after, &event.After}} {
+		if snapshot.value == nil {
+			continue
+		}
+		data, err := json.Marshal(snapshot.value)
+		if err != nil {
+			return err
+		}
+		*snapshot.dest = string(data)
+	}
+
+	return tx.Create(&event).Error
+}
+
+// Audit Handlers
+
+// GetAuditEvents lists audit events, newest first. Supports actor_id, action,
+// target_type, target_id, from and to, with limit and offset. format=csv
+// downloads every matching event instead, oldest first.
+func (h *Handler) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query := h.db.Model(&models.AuditEvent{})
+	for _, filter := range []string{"actor_id", "target_id"} {
+		value := params.Get(filter)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeJSONError(w, filter+" must be a number", http.StatusBadRequest)
+			return
+		}
+		query = query.Where(filter+" = ?", uint(id))
+	}
+	if action := params.Get("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if targetType := params.Get("target_type"); targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+
+	from, err := parseTimeParam(params.Get("from"))
+	if err != nil {
+		writeJSONError(w, "from must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(params.Get("to"))
+	if err != nil {
+		writeJSONError(w, "to must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+
+	if params.Get("format") == "csv" {
+		writeAuditCSV(w, query)
+		return
+	}
+
+	limit, offset := parsePagination(r, 50, 200)
+	response := AuditEventsResponse{Events: []models.AuditEvent{}}
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&response.Total).Error; err != nil {
+		writeJSONError(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	if err := query.Preload("Actor").Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&response.Events).Error; err != nil {
+		writeJSONError(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// writeAuditCSV streams the events matching query as a CSV download, loading
+// them in batches so large exports don't have to fit in memory
+func writeAuditCSV(w http.ResponseWriter, query *gorm.DB) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().UTC().Format("20060102-150405")+`.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "actor_id", "actor_username", "action", "target_type", "target_id", "ip", "before", "after"})
+
+	var events []models.AuditEvent
+	err := query.Preload("Actor").FindInBatches(&events, auditCSVBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, event := range events {
+			var actorID, actorUsername string
+			if event.ActorID != nil {
+				actorID = strconv.FormatUint(uint64(*event.ActorID), 10)
+			}
+			if event.Actor != nil {
+				actorUsername = event.Actor.Username
+			}
+			record := []string{
+				strconv.FormatUint(uint64(event.ID), 10),
+				event.CreatedAt.UTC().Format(time.RFC3339),
+				actorID,
+				actorUsername,
+				event.Action,
+				event.TargetType,
+				strconv.FormatUint(uint64(event.TargetID), 10),
+				event.IP,
+				event.Before,
+				event.After,
+			}
+			for i := range record {
+				record[i] = csvCell(record[i])
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	}).Error
+	if err != nil {
+		// Headers are already sent, so all we can do is cut the export short
+		log.Printf("Failed to export audit log: %v", err)
+		return
+	}
+	out.Flush()
+}
+
+// csvCell keeps spreadsheet apps from running a cell as a formula. Values
+// such as usernames are user-controlled, so any that start like a formula
+// are prefixed with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,37 +14,115 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"koinonia-backend/lockout"
+	"koinonia-backend/mail"
 	"koinonia-backend/models"
+	"koinonia-backend/oidc"
+	"koinonia-backend/storage"
 )
 
 // Handler holds database connection and provides HTTP handlers
 type Handler struct {
-	db *gorm.DB
+	db  *gorm.DB
+	cfg Config
 }
 
-// New creates a new handler instance
-func New(db *gorm.DB) *Handler {
-	return &Handler{db: db}
+// Config holds tunable settings for the handlers
+type Config struct {
+	// ScriptureApproveThreshold is the minimum recitation accuracy (0.0 - 1.0)
+	// at which scripture submissions are approved without admin review
+	ScriptureApproveThreshold float64
+
+	// SeasonStart and SeasonEnd bound the current season for the season
+	// leaderboard; SeasonEnd may be nil for an open-ended season
+	SeasonStart *time.Time
+	SeasonEnd   *time.Time
+
+	// Storage holds uploaded media; uploads are disabled when nil
+	Storage storage.Storage
+
+	// MaxUploadSize is the largest accepted upload in bytes
+	MaxUploadSize int64
+
+	// MaxImageDimension is the longest edge, in pixels, uploaded photos are resized to
+	MaxImageDimension int
+
+	// AccessTokenTTL is how long issued JWTs are valid; RefreshTokenTTL is
+	// how long a session can go unused before its refresh token expires
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Keys signs and verifies access tokens
+	Keys *KeySet
+
+	// Mailer sends account emails; AppURL is the frontend base URL used in links
+	Mailer mail.Mailer
+	AppURL string
+
+	// PasswordResetTTL is how long a password reset link stays valid;
+	// PasswordResetResendInterval is the minimum time between links an
+	// account can request
+	PasswordResetTTL            time.Duration
+	PasswordResetResendInterval time.Duration
+
+	// VerificationTTL is how long an email verification link stays valid;
+	// VerificationResendInterval is the minimum time between resends
+	VerificationTTL            time.Duration
+	VerificationResendInterval time.Duration
+
+	// OIDCProviders are the single sign-on providers users can log in with
+	OIDCProviders []*oidc.Provider
+
+	// AccountLockout and IPLockout throttle failed logins per account and
+	// per client IP
+	AccountLockout *lockout.Limiter
+	IPLockout      *lockout.Limiter
+
+	// RequireAdminTwoFactor keeps staff (any role with permissions) out of
+	// permission-protected routes until they enable two-factor authentication
+	RequireAdminTwoFactor bool
+
+	// RequireInviteCode makes registration invite-only. AllowedEmailDomains
+	// (lower case, e.g. "university.edu") admits addresses at those domains
+	// without a code; on its own it limits registration to them.
+	RequireInviteCode   bool
+	AllowedEmailDomains []string
+
+	// ShowReviewerToUsers lets users see who reviewed their submissions;
+	// otherwise only staff who review submissions can
+	ShowReviewerToUsers bool
+
+	// Location is the time zone recurring quests and featured days are
+	// reckoned in; nil means the server's local time zone
+	Location *time.Location
+
+	// FeaturedQuestCount is how many quests are featured each day
+	FeaturedQuestCount int
 }
 
-// JWT secret key - in production, this should be in environment variables
-var jwtSecret = []byte("your-secret-key-change-this-in-production")
+// New creates a new handler instance
+func New(db *gorm.DB, cfg Config) *Handler {
+	return &Handler{db: db, cfg: cfg}
+}
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Role   string `json:"role"`
+	UserID    uint   `json:"user_id"`
+	Role      string `json:"role"`
+	SessionID uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
 // Response structures
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`         // Short-lived access token
+	RefreshToken string      `json:"refresh_token"` // Exchange at /api/auth/refresh for a new token
+	User         models.User `json:"user"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"` // Machine-readable reason, when available
 }
 
 type MessageResponse struct {
@@ -54,11 +134,12 @@ type MessageResponse struct {
 // Register creates a new user account
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Username  string `json:"username"`
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
+		Username   string `json:"username"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		FirstName  string `json:"first_name"`
+		LastName   string `json:"last_name"`
+		InviteCode string `json:"invite_code"`
 	}
 
 	// Parse JSON request
@@ -87,34 +168,48 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create user
+	// Create user; the email stays unverified until the emailed link is followed
+	now := time.Now()
 	user := models.User{
-		Username:  req.Username,
-		Email:     req.Email,
-		Password:  string(hashedPassword),
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Role:      "user", // Default role
-		IsActive:  true,
+		Username:           req.Username,
+		Email:              req.Email,
+		Password:           string(hashedPassword),
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		Role:               models.RoleUser, // Default role
+		IsActive:           true,
+		VerificationSentAt: &now,
 	}
 
-	if err := h.db.Create(&user).Error; err != nil {
-		writeJSONError(w, "Failed to create user", http.StatusInternalServerError)
+	// Check the invite and email domain gates, using up the invite code with
+	// the account
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		invite, err := h.admitRegistration(tx, req.Email, req.InviteCode)
+		if err != nil {
+			return err
+		}
+		if invite != nil {
+			user.Role = invite.Role
+			user.InviteCodeID = &invite.ID
+		}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		writeRegistrationError(w, err)
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.generateJWT(user.ID, user.Role)
+	if err := h.sendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to create verification email for user %d: %v", user.ID, err)
+	}
+
+	// Start a session and generate tokens
+	response, err := h.createSession(&user, r, models.LoginMethodPassword)
 	if err != nil {
 		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Return response
-	response := AuthResponse{
-		Token: token,
-		User:  user,
-	}
 	writeJSON(w, response, http.StatusCreated)
 }
 
@@ -139,10 +234,38 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Find user by username or email
 	var user models.User
-	if err := h.db.Where("username = ? OR email = ?", req.Username, req.Username).First(&user).Error; err != nil {
+	found := h.db.Where("username = ? OR email = ?", req.Username, req.Username).First(&user).Error == nil
+
+	// Throttle repeated failures. Unknown names are throttled and timed
+	// like real accounts so responses don't reveal which accounts exist.
+	lockoutKey := unknownLockoutKey(req.Username)
+	var lockoutUserID *uint
+	if found {
+		lockoutKey, lockoutUserID = accountLockoutKey(user.ID), &user.ID
+	}
+	attempt, wait, err := h.reserveLoginAttempt(r, lockoutKey)
+	if err != nil {
+		writeJSONError(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	// Verify password
+	if !found {
+		compareDummyPassword(req.Password)
+		attempt.failed(h, r, nil)
 		writeJSONError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		attempt.failed(h, r, lockoutUserID)
+		writeJSONError(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	attempt.succeeded(h, r)
 
 	// Check if user is active
 	if !user.IsActive {
@@ -150,9 +273,14 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		writeJSONError(w, "Invalid credentials", http.StatusUnauthorized)
+	// Users with two-factor authentication finish logging in at /api/auth/2fa/verify
+	if user.TwoFactorEnabledAt != nil {
+		challenge, err := h.twoFactorChallenge(&user)
+		if err != nil {
+			writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, TwoFactorChallengeResponse{TwoFactorRequired: true, TwoFactorToken: challenge}, http.StatusOK)
 		return
 	}
 
@@ -161,18 +289,13 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	user.LastLogin = &now
 	h.db.Save(&user)
 
-	// Generate JWT token
-	token, err := h.generateJWT(user.ID, user.Role)
+	// Start a session and generate tokens
+	response, err := h.createSession(&user, r, models.LoginMethodPassword)
 	if err != nil {
 		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Return response
-	response := AuthResponse{
-		Token: token,
-		User:  user,
-	}
 	writeJSON(w, response, http.StatusOK)
 }
 
@@ -228,19 +351,28 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 
-// generateJWT creates a JWT token for a user
-func (h *Handler) generateJWT(userID uint, role string) (string, error) {
+// randomToken returns n random bytes encoded as hex
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateJWT creates a short-lived access token for a user's session
+func (h *Handler) generateJWT(userID uint, role string, sessionID uint) (string, error) {
 	claims := Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.cfg.AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return h.cfg.Keys.sign(claims)
 }
 
 // writeJSON writes a JSON response
@@ -257,9 +389,56 @@ func writeJSONError(w http.ResponseWriter, message string, status int) {
 	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 }
 
+// writeJSONErrorCode writes a JSON error response with a machine-readable code
+func writeJSONErrorCode(w http.ResponseWriter, message, code string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
 // parseID parses an ID from URL parameter
 func parseID(r *http.Request, param string) (uint, error) {
 	idStr := chi.URLParam(r, param)
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	return uint(id), err
 }
+
+// queryInt parses a non-negative integer query parameter with fallback
+func queryInt(r *http.Request, key string, fallback int) int {
+	if value := r.URL.Query().Get(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// queryBool parses a boolean query parameter, defaulting to false
+func queryBool(r *http.Request, key string) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return value
+}
+
+// parseTimeParam accepts an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// parsePagination reads limit and offset query parameters, capping the limit
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (int, int) {
+	limit := queryInt(r, "limit", defaultLimit)
+	if limit == 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, queryInt(r, "offset", 0)
+}
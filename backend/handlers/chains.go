@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"koinonia-backend/models"
+)
+
+var (
+	// errUnknownQuest is returned when a prerequisite or chain names a quest
+	// that doesn't exist
+	errUnknownQuest = errors.New("unknown quest")
+	// errSelfPrerequisite is returned when a quest is made its own prerequisite
+	errSelfPrerequisite = errors.New("quest cannot require itself")
+	// errPrerequisiteCycle is returned when prerequisites would make quests
+	// require each other
+	errPrerequisiteCycle = errors.New("prerequisites form a cycle")
+	// errQuestInOtherChain is returned when a chain claims another chain's quest
+	errQuestInOtherChain = errors.New("quest belongs to another chain")
+	// errChainNotFound is returned when a chain action targets a missing chain
+	errChainNotFound = errors.New("chain not found")
+)
+
+// ChainResponse is a chain with its published quests and the user's progress
+type ChainResponse struct {
+	models.QuestChain
+	CompletedQuests int        `json:"completed_quests"` // Quests the user has had approved
+	TotalQuests     int        `json:"total_quests"`
+	CompletedAt     *time.Time `json:"completed_at"` // When the user earned the bonus
+}
+
+// chainRequest is the staff payload for creating or replacing a chain
+type chainRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	BonusPoints int    `json:"bonus_points"`
+	Ordered     bool   `json:"ordered"`
+	QuestIDs    []uint `json:"quest_ids"` // Quests in order; omit to leave them unchanged
+}
+
+// Quest Chain Handlers
+
+// GetChains lists quest chains with the user's progress through each
+func (h *Handler) GetChains(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var chains []models.QuestChain
+	if err := h.db.Order("created_at DESC, id DESC").Find(&chains).Error; err != nil {
+		writeJSONError(w, "Failed to fetch chains", http.StatusInternalServerError)
+		return
+	}
+
+	responses, err := h.chainResponses(userID, chains)
+	if err != nil {
+		writeJSONError(w, "Failed to fetch chains", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, responses, http.StatusOK)
+}
+
+// GetChain returns a quest chain with the user's progress
+func (h *Handler) GetChain(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	chainID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	var chain models.QuestChain
+	if err := h.db.First(&chain, chainID).Error; err != nil {
+		writeJSONError(w, "Chain not found", http.StatusNotFound)
+		return
+	}
+
+	responses, err := h.chainResponses(userID, []models.QuestChain{chain})
+	if err != nil {
+		writeJSONError(w, "Failed to fetch chain", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, responses[0], http.StatusOK)
+}
+
+// CreateChain creates a quest chain from quests in order
+func (h *Handler) CreateChain(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeChainRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chain := models.QuestChain{
+		Title:       req.Title,
+		Description: req.Description,
+		BonusPoints: req.BonusPoints,
+		Ordered:     req.Ordered,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&chain).Error; err != nil {
+			return err
+		}
+		if err := setChainQuests(tx, chain.ID, req.QuestIDs); err != nil {
+			return err
+		}
+		if err := checkPrerequisiteCycles(tx); err != nil {
+			return err
+		}
+		after, err := loadChain(tx, chain.ID)
+		if err != nil {
+			return err
+		}
+		chain = *after
+		return recordAudit(tx, r, auditChainCreate, auditTargetChain, chain.ID, nil, chain)
+	})
+	if err != nil {
+		writeChainError(w, err, "Failed to create chain")
+		return
+	}
+
+	writeJSON(w, chain, http.StatusCreated)
+}
+
+// UpdateChain replaces a chain's details and, if quest_ids is given, its
+// quests and their order
+func (h *Handler) UpdateChain(w http.ResponseWriter, r *http.Request) {
+	chainID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	req, err := decodeChainRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var chain models.QuestChain
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := loadChain(tx.Clauses(clause.Locking{Strength: "UPDATE"}), chainID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.QuestChain{}).Where("id = ?", chainID).Updates(map[string]interface{}{
+			"title":        req.Title,
+			"description":  req.Description,
+			"bonus_points": req.BonusPoints,
+			"ordered":      req.Ordered,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if req.QuestIDs != nil {
+			if err := setChainQuests(tx, chainID, req.QuestIDs); err != nil {
+				return err
+			}
+		}
+
+		// Reordering can make quests require each other through explicit
+		// prerequisites
+		if err := checkPrerequisiteCycles(tx); err != nil {
+			return err
+		}
+
+		after, err := loadChain(tx, chainID)
+		if err != nil {
+			return err
+		}
+		chain = *after
+		return recordAudit(tx, r, auditChainUpdate, auditTargetChain, chainID, before, chain)
+	})
+	if err != nil {
+		writeChainError(w, err, "Failed to update chain")
+		return
+	}
+
+	writeJSON(w, chain, http.StatusOK)
+}
+
+// DeleteChain deletes a chain (soft delete). Its quests are kept and become
+// standalone; bonuses already awarded are kept.
+func (h *Handler) DeleteChain(w http.ResponseWriter, r *http.Request) {
+	chainID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := loadChain(tx.Clauses(clause.Locking{Strength: "UPDATE"}), chainID)
+		if err != nil {
+			return err
+		}
+		if err := setChainQuests(tx, chainID, []uint{}); err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.QuestChain{}, chainID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditChainDelete, auditTargetChain, chainID, before, nil)
+	})
+	if err != nil {
+		writeChainError(w, err, "Failed to delete chain")
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Chain deleted successfully"}, http.StatusOK)
+}
+
+// decodeChainRequest parses and validates a chain payload
+func decodeChainRequest(r *http.Request) (chainRequest, error) {
+	var req chainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, errors.New("Invalid JSON")
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return req, errors.New("Title is required")
+	}
+	if req.BonusPoints < 0 {
+		return req, errors.New("Bonus points cannot be negative")
+	}
+
+	seen := map[uint]bool{}
+	for _, id := range req.QuestIDs {
+		if seen[id] {
+			return req, errors.New("A quest can only appear once in a chain")
+		}
+		seen[id] = true
+	}
+	return req, nil
+}
+
+// writeChainError reports a failed chain change
+func writeChainError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, errChainNotFound):
+		writeJSONError(w, "Chain not found", http.StatusNotFound)
+	case errors.Is(err, errUnknownQuest):
+		writeJSONError(w, "Chain quests must exist", http.StatusBadRequest)
+	case errors.Is(err, errQuestInOtherChain):
+		writeJSONError(w, "A quest can only belong to one chain", http.StatusConflict)
+	case errors.Is(err, errPrerequisiteCycle):
+		writeJSONError(w, "That order would make quests require each other", http.StatusBadRequest)
+	default:
+		writeJSONError(w, message, http.StatusInternalServerError)
+	}
+}
+
+// chainResponses adds the user's progress and published quests to chains.
+// Quests are listed in chain order with their lock state.
+func (h *Handler) chainResponses(userID uint, chains []models.QuestChain) ([]ChainResponse, error) {
+	responses := make([]ChainResponse, len(chains))
+	if len(chains) == 0 {
+		return responses, nil
+	}
+
+	chainIDs := make([]uint, len(chains))
+	for i, chain := range chains {
+		chainIDs[i] = chain.ID
+	}
+
+	var quests []models.Quest
+	if err := h.db.Where("chain_id IN ? AND is_active = ?", chainIDs, true).
+		Order("chain_position, id").Find(&quests).Error; err != nil {
+		return nil, err
+	}
+	if err := h.applyQuestLocks(h.db, userID, quests); err != nil {
+		return nil, err
+	}
+
+	approved, err := approvedQuestIDs(h.db, userID, questIDs(quests))
+	if err != nil {
+		return nil, err
+	}
+
+	var completions []models.ChainCompletion
+	if err := h.db.Where("user_id = ? AND chain_id IN ?", userID, chainIDs).Find(&completions).Error; err != nil {
+		return nil, err
+	}
+	completedAt := map[uint]*time.Time{}
+	for i := range completions {
+		completedAt[completions[i].ChainID] = &completions[i].CreatedAt
+	}
+
+	byChain := map[uint]int{}
+	for i, chain := range chains {
+		byChain[chain.ID] = i
+		responses[i] = ChainResponse{QuestChain: chain, CompletedAt: completedAt[chain.ID]}
+		responses[i].Quests = []models.Quest{}
+	}
+	for _, quest := range quests {
+		response := &responses[byChain[*quest.ChainID]]
+		response.Quests = append(response.Quests, quest)
+		response.TotalQuests++
+		if approved[quest.ID] {
+			response.CompletedQuests++
+		}
+	}
+	return responses, nil
+}
+
+// loadChain loads a chain with its quests in order
+func loadChain(tx *gorm.DB, chainID uint) (*models.QuestChain, error) {
+	var chain models.QuestChain
+	err := tx.Preload("Quests", func(db *gorm.DB) *gorm.DB {
+		return db.Order("chain_position, id")
+	}).First(&chain, chainID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errChainNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chain, nil
+}
+
+// setChainQuests makes questIDs, in order, the chain's quests, detaching any
+// quests no longer listed
+func setChainQuests(tx *gorm.DB, chainID uint, ids []uint) error {
+	if len(ids) > 0 {
+		var taken int64
+		if err := tx.Model(&models.Quest{}).
+			Where("id IN ? AND chain_id IS NOT NULL AND chain_id <> ?", ids, chainID).
+			Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errQuestInOtherChain
+		}
+	}
+
+	err := tx.Model(&models.Quest{}).Where("chain_id = ?", chainID).
+		Updates(map[string]interface{}{"chain_id": nil, "chain_position": 0}).Error
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		result := tx.Model(&models.Quest{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"chain_id": chainID, "chain_position": i + 1})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errUnknownQuest
+		}
+	}
+	return nil
+}
+
+// completeChain awards the bonus of the chain a newly approved submission's
+// quest belongs to, if that approval completes the chain for the user. It
+// must be called inside the approval's transaction.
+func completeChain(tx *gorm.DB, submission *models.Submission) error {
+	var quest models.Quest
+	if err := tx.Select("id", "chain_id").First(&quest, submission.QuestID).Error; err != nil {
+		return err
+	}
+	if quest.ChainID == nil {
+		return nil
+	}
+
+	var chain models.QuestChain
+	err := tx.First(&chain, *quest.ChainID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only published quests can be completed, so only they count
+	var total, completed int64
+	if err := tx.Model(&models.Quest{}).Where("chain_id = ? AND is_active = ?", chain.ID, true).
+		Count(&total).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Submission{}).
+		Joins("JOIN quests ON quests.id = submissions.quest_id AND quests.deleted_at IS NULL").
+		Where("submissions.user_id = ? AND submissions.status = ?", submission.UserID, models.SubmissionStatusApproved).
+		Where("quests.chain_id = ? AND quests.is_active = ?", chain.ID, true).
+		Distinct("submissions.quest_id").
+		Count(&completed).Error; err != nil {
+		return err
+	}
+	if total == 0 || completed < total {
+		return nil
+	}
+
+	// The unique index makes sure the bonus is only ever awarded once
+	completion := models.ChainCompletion{UserID: submission.UserID, ChainID: chain.ID, BonusPoints: chain.BonusPoints}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&completion)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 || chain.BonusPoints == 0 {
+		return nil
+	}
+
+	return recordPoints(tx, &models.PointTransaction{
+		UserID:  submission.UserID,
+		Amount:  chain.BonusPoints,
+		Reason:  models.PointReasonChainBonus,
+		Note:    chain.Title,
+		ChainID: &chain.ID,
+	})
+}
+
+// Prerequisites
+
+// prerequisiteGraph returns the prerequisites of each quest in questIDs, or
+// of every quest when questIDs is nil: its explicit prerequisites and, in
+// an ordered chain, the quest before it. With publishedOnly, drafts are
+// skipped since they can't be completed; without it, the graph includes
+// them so drafts can't be published into a cycle.
+func prerequisiteGraph(tx *gorm.DB, ids []uint, publishedOnly bool) (map[uint][]uint, error) {
+	graph := map[uint][]uint{}
+
+	var edges []models.QuestPrerequisite
+	explicit := tx.Model(&models.QuestPrerequisite{}).
+		Joins("JOIN quests ON quests.id = quest_prerequisites.prerequisite_id AND quests.deleted_at IS NULL")
+	if publishedOnly {
+		explicit = explicit.Where("quests.is_active = ?", true)
+	}
+	if ids != nil {
+		explicit = explicit.Where("quest_prerequisites.quest_id IN ?", ids)
+	}
+	if err := explicit.Select("quest_prerequisites.quest_id, quest_prerequisites.prerequisite_id").Find(&edges).Error; err != nil {
+		return nil, err
+	}
+	for _, edge := range edges {
+		graph[edge.QuestID] = append(graph[edge.QuestID], edge.PrerequisiteID)
+	}
+
+	// Ordered chains, with their published quests and the quests asked about
+	var chained []models.Quest
+	chains := tx.Model(&models.Quest{}).
+		Joins("JOIN quest_chains ON quest_chains.id = quests.chain_id AND quest_chains.deleted_at IS NULL AND quest_chains.ordered = ?", true).
+		Select("quests.id, quests.chain_id, quests.chain_position, quests.is_active")
+	if ids != nil {
+		chains = chains.Where("quests.chain_id IN (?)", tx.Model(&models.Quest{}).Select("chain_id").Where("id IN ?", ids))
+	}
+	if err := chains.Order("quests.chain_id, quests.chain_position, quests.id").Find(&chained).Error; err != nil {
+		return nil, err
+	}
+
+	var previous *models.Quest
+	for i := range chained {
+		quest := &chained[i]
+		if previous != nil && *previous.ChainID != *quest.ChainID {
+			previous = nil
+		}
+		if previous != nil {
+			graph[quest.ID] = append(graph[quest.ID], previous.ID)
+		}
+		if quest.IsActive || !publishedOnly {
+			previous = quest
+		}
+	}
+
+	if ids != nil {
+		wanted := map[uint]bool{}
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		for id := range graph {
+			if !wanted[id] {
+				delete(graph, id)
+			}
+		}
+	}
+	for id, prereqs := range graph {
+		graph[id] = uniqueIDs(prereqs)
+	}
+	return graph, nil
+}
+
+// setQuestPrerequisites replaces a quest's explicit prerequisites
+func setQuestPrerequisites(tx *gorm.DB, questID uint, ids []uint) error {
+	ids = uniqueIDs(ids)
+	for _, id := range ids {
+		if id == questID {
+			return errSelfPrerequisite
+		}
+	}
+	if len(ids) > 0 {
+		var count int64
+		if err := tx.Model(&models.Quest{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+			return err
+		}
+		if count != int64(len(ids)) {
+			return errUnknownQuest
+		}
+	}
+
+	if err := tx.Where("quest_id = ?", questID).Delete(&models.QuestPrerequisite{}).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tx.Create(&models.QuestPrerequisite{QuestID: questID, PrerequisiteID: id}).Error; err != nil {
+			return err
+		}
+	}
+	return checkPrerequisiteCycles(tx)
+}
+
+// checkPrerequisiteCycles returns errPrerequisiteCycle if any quests
+// require each other, directly or through other quests
+func checkPrerequisiteCycles(tx *gorm.DB) error {
+	graph, err := prerequisiteGraph(tx, nil, false)
+	if err != nil {
+		return err
+	}
+
+	// Depth-first search; a quest seen again while still on the stack closes a cycle
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[uint]int{}
+	var visit func(id uint) bool
+	visit = func(id uint) bool {
+		switch state[id] {
+		case visiting:
+			return true
+		case done:
+			return false
+		}
+		state[id] = visiting
+		for _, prereq := range graph[id] {
+			if visit(prereq) {
+				return true
+			}
+		}
+		state[id] = done
+		return false
+	}
+
+	for id := range graph {
+		if visit(id) {
+			return errPrerequisiteCycle
+		}
+	}
+	return nil
+}
+
+// loadPrerequisiteIDs fills in a quest's explicit prerequisites, as set by
+// quest authors
+func loadPrerequisiteIDs(tx *gorm.DB, quest *models.Quest) error {
+	quest.PrerequisiteIDs = []uint{}
+	return tx.Model(&models.QuestPrerequisite{}).Where("quest_id = ?", quest.ID).
+		Order("prerequisite_id").Pluck("prerequisite_id", &quest.PrerequisiteIDs).Error
+}
+
+// writePrerequisiteError reports an invalid prerequisite change, returning
+// false if err isn't one
+func writePrerequisiteError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errUnknownQuest):
+		writeJSONError(w, "Prerequisite quests must exist", http.StatusBadRequest)
+	case errors.Is(err, errSelfPrerequisite):
+		writeJSONError(w, "A quest cannot be its own prerequisite", http.StatusBadRequest)
+	case errors.Is(err, errPrerequisiteCycle):
+		writeJSONError(w, "Prerequisites cannot make quests require each other", http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
+
+// missingPrerequisites returns the titles of the quest's prerequisites the
+// user has yet to complete
+func (h *Handler) missingPrerequisites(userID uint, quest *models.Quest) ([]string, error) {
+	quests := []models.Quest{*quest}
+	if err := h.applyQuestLocks(h.db, userID, quests); err != nil {
+		return nil, err
+	}
+	if !quests[0].Locked {
+		return nil, nil
+	}
+
+	approved, err := approvedQuestIDs(h.db, userID, quests[0].PrerequisiteIDs)
+	if err != nil {
+		return nil, err
+	}
+	var missing []uint
+	for _, id := range quests[0].PrerequisiteIDs {
+		if !approved[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	var titles []string
+	if err := h.db.Model(&models.Quest{}).Where("id IN ?", missing).Order("id").
+		Pluck("title", &titles).Error; err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// applyQuestLocks fills in each quest's prerequisites and whether the user
+// has yet to complete any of them
+func (h *Handler) applyQuestLocks(tx *gorm.DB, userID uint, quests []models.Quest) error {
+	if len(quests) == 0 {
+		return nil
+	}
+
+	graph, err := prerequisiteGraph(tx, questIDs(quests), true)
+	if err != nil {
+		return err
+	}
+	var required []uint
+	for _, prereqs := range graph {
+		required = append(required, prereqs...)
+	}
+	approved, err := approvedQuestIDs(tx, userID, uniqueIDs(required))
+	if err != nil {
+		return err
+	}
+
+	for i := range quests {
+		quests[i].PrerequisiteIDs = graph[quests[i].ID]
+		if quests[i].PrerequisiteIDs == nil {
+			quests[i].PrerequisiteIDs = []uint{}
+		}
+		quests[i].Locked = false
+		for _, id := range quests[i].PrerequisiteIDs {
+			if !approved[id] {
+				quests[i].Locked = true
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// approvedQuestIDs returns which of the quests the user has an approved
+// submission for
+func approvedQuestIDs(tx *gorm.DB, userID uint, ids []uint) (map[uint]bool, error) {
+	approved := map[uint]bool{}
+	if len(ids) == 0 {
+		return approved, nil
+	}
+
+	var found []uint
+	err := tx.Model(&models.Submission{}).
+		Where("user_id = ? AND status = ? AND quest_id IN ?", userID, models.SubmissionStatusApproved, ids).
+		Distinct().Pluck("quest_id", &found).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		approved[id] = true
+	}
+	return approved, nil
+}
+
+// questIDs returns the IDs of quests
+func questIDs(quests []models.Quest) []uint {
+	ids := make([]uint, len(quests))
+	for i, quest := range quests {
+		ids[i] = quest.ID
+	}
+	return ids
+}
+
+// uniqueIDs sorts IDs and drops repeats
+func uniqueIDs(ids []uint) []uint {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var unique []uint
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
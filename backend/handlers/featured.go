@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// featuredRotationLock is the Postgres advisory lock key held while picking
+// a day's featured quests, so concurrent servers agree on one set
+const featuredRotationLock = 0x6b6f696e6f6e6961
+
+// RotateFeaturedQuests picks the featured quests for the day containing now
+// unless they have been picked already, and returns that day's picks. Quests
+// come from the feature pool of published quests within their availability
+// window, least recently featured first; force replaces an existing pick.
+func (h *Handler) RotateFeaturedQuests(ctx context.Context, now time.Time, force bool) ([]models.FeaturedQuest, error) {
+	date := h.featuredDate(now)
+	db := h.db.WithContext(ctx)
+
+	// Most calls find the day already picked, so check before locking
+	var picks []models.FeaturedQuest
+	if !force {
+		if err := db.Where("date = ?", date).Order("position").Find(&picks).Error; err != nil {
+			return nil, err
+		}
+		if len(picks) > 0 {
+			return picks, nil
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", featuredRotationLock).Error; err != nil {
+			return err
+		}
+
+		if force {
+			if err := tx.Where("date = ?", date).Delete(&models.FeaturedQuest{}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Where("date = ?", date).Order("position").Find(&picks).Error; err != nil {
+				return err
+			}
+			if len(picks) > 0 {
+				return nil
+			}
+		}
+
+		lastFeatured := tx.Model(&models.FeaturedQuest{}).
+			Select("quest_id, MAX(date) AS last_date").
+			Where("date < ?", date).
+			Group("quest_id")
+
+		var questIDs []uint
+		err := tx.Model(&models.Quest{}).
+			Joins("LEFT JOIN (?) AS featured ON featured.quest_id = quests.id", lastFeatured).
+			Where("quests.is_active = ? AND quests.feature_pool = ?", true, true).
+			Where("(quests.start_date IS NULL OR quests.start_date <= ?) AND (quests.end_date IS NULL OR quests.end_date > ?)", now, now).
+			Order("featured.last_date NULLS FIRST, RANDOM()").
+			Limit(h.cfg.FeaturedQuestCount).
+			Pluck("quests.id", &questIDs).Error
+		if err != nil {
+			return err
+		}
+
+		picks = make([]models.FeaturedQuest, len(questIDs))
+		for i, id := range questIDs {
+			picks[i] = models.FeaturedQuest{Date: date, Position: i, QuestID: id}
+		}
+		if len(picks) == 0 {
+			return nil
+		}
+		return tx.Create(&picks).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return picks, nil
+}
+
+// featuredQuestIDs returns the IDs of the quests featured on the day
+// containing now
+func (h *Handler) featuredQuestIDs(now time.Time) (map[uint]bool, error) {
+	var ids []uint
+	err := h.db.Model(&models.FeaturedQuest{}).Where("date = ?", h.featuredDate(now)).Pluck("quest_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	featured := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		featured[id] = true
+	}
+	return featured, nil
+}
+
+// featuredDate returns the featured rotation's day for now
+func (h *Handler) featuredDate(now time.Time) string {
+	return now.In(h.location()).Format("2006-01-02")
+}
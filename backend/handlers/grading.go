@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"koinonia-backend/models"
+)
+
+// errInvalidTriviaOption is returned when an answer is not one of the quest's options
+var errInvalidTriviaOption = errors.New("answer is not one of the available options")
+
+// gradeSubmission grades a new submission on arrival. It returns the status
+// the submission should move to (pending when an admin must review it) and
+// the notes to record. Scripture grading details are stored on the submission.
+func (h *Handler) gradeSubmission(quest *models.Quest, submission *models.Submission) (models.SubmissionStatus, string, error) {
+	switch {
+	case triviaAutoGradable(quest):
+		correct, err := gradeTrivia(quest, submission.Content)
+		if err != nil {
+			return "", "", err
+		}
+		if correct {
+			return models.SubmissionStatusApproved, "Correct answer", nil
+		}
+		return models.SubmissionStatusRejected, "Incorrect answer", nil
+
+	case quest.Type == models.QuestTypeScripture && quest.ScriptureText != "":
+		grade := gradeScripture(quest.ScriptureText, submission.Content)
+		diff, err := json.Marshal(grade)
+		if err != nil {
+			return "", "", err
+		}
+		submission.Accuracy = &grade.Accuracy
+		submission.GradingDiff = string(diff)
+
+		// Recitations below the threshold stay pending for an admin to review
+		if grade.Accuracy >= h.cfg.ScriptureApproveThreshold {
+			return models.SubmissionStatusApproved, fmt.Sprintf("Recited with %.0f%% accuracy", grade.Accuracy*100), nil
+		}
+	}
+
+	return models.SubmissionStatusPending, "", nil
+}
+
+// Trivia Grading
+
+// triviaAutoGradable reports whether a quest can be graded without an admin
+func triviaAutoGradable(quest *models.Quest) bool {
+	return quest.Type == models.QuestTypeTrivia && strings.TrimSpace(quest.CorrectAnswer) != ""
+}
+
+// gradeTrivia checks an answer against the quest's correct answer. If the
+// quest defines multiple-choice options, the answer must match one of them.
+func gradeTrivia(quest *models.Quest, answer string) (bool, error) {
+	answer = normalizeAnswer(answer)
+	if answer == "" {
+		return false, errInvalidTriviaOption
+	}
+
+	options, err := parseTriviaOptions(quest.TriviaOptions)
+	if err != nil {
+		return false, err
+	}
+	if len(options) > 0 {
+		valid := false
+		for _, option := range options {
+			if normalizeAnswer(option) == answer {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return false, errInvalidTriviaOption
+		}
+	}
+
+	return answer == normalizeAnswer(quest.CorrectAnswer), nil
+}
+
+// parseTriviaOptions decodes the JSON array stored in Quest.TriviaOptions
+func parseTriviaOptions(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var options []string
+	if err := json.Unmarshal([]byte(raw), &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// normalizeAnswer makes answer comparison case- and whitespace-insensitive
+func normalizeAnswer(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// Scripture Grading
+
+// Word diff operations produced by gradeScripture
+const (
+	DiffOpMatch   = "match"   // Word recited correctly
+	DiffOpMissed  = "missed"  // Word in the reference that was left out
+	DiffOpAdded   = "added"   // Extra word that is not in the reference
+	DiffOpSwapped = "swapped" // Word recited in place of the reference word
+)
+
+// WordDiff is a single step in the alignment between a recitation and the reference text
+type WordDiff struct {
+	Op       string `json:"op"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// ScriptureGrade is the result of grading a scripture recitation
+type ScriptureGrade struct {
+	Accuracy float64    `json:"accuracy"` // 0.0 - 1.0
+	Missed   int        `json:"missed"`
+	Added    int        `json:"added"`
+	Swapped  int        `json:"swapped"`
+	Diff     []WordDiff `json:"diff"`
+}
+
+// gradeScripture aligns a recitation against the reference text word by word
+// and scores it as 1 - (edit distance / reference length).
+func gradeScripture(reference, recitation string) ScriptureGrade {
+	expected := scriptureWords(reference)
+	actual := scriptureWords(recitation)
+
+	// dist[i][j] is the edit distance between expected[i:] and actual[j:]
+	n, m := len(expected), len(actual)
+	dist := make([][]int, n+1)
+	for i := range dist {
+		dist[i] = make([]int, m+1)
+	}
+	for i := n; i >= 0; i-- {
+		for j := m; j >= 0; j-- {
+			switch {
+			case i == n:
+				dist[i][j] = m - j
+			case j == m:
+				dist[i][j] = n - i
+			case expected[i] == actual[j]:
+				dist[i][j] = dist[i+1][j+1]
+			default:
+				dist[i][j] = 1 + min(dist[i+1][j+1], dist[i+1][j], dist[i][j+1])
+			}
+		}
+	}
+
+	// Walk the table from the start to recover the alignment
+	grade := ScriptureGrade{Diff: make([]WordDiff, 0, max(n, m))}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && expected[i] == actual[j]:
+			grade.Diff = append(grade.Diff, WordDiff{Op: DiffOpMatch, Expected: expected[i], Actual: actual[j]})
+			i, j = i+1, j+1
+		case i < n && j < m && dist[i][j] == dist[i+1][j+1]+1:
+			grade.Diff = append(grade.Diff, WordDiff{Op: DiffOpSwapped, Expected: expected[i], Actual: actual[j]})
+			grade.Swapped++
+			i, j = i+1, j+1
+		case i < n && (j == m || dist[i][j] == dist[i+1][j]+1):
+			grade.Diff = append(grade.Diff, WordDiff{Op: DiffOpMissed, Expected: expected[i]})
+			grade.Missed++
+			i++
+		default:
+			grade.Diff = append(grade.Diff, WordDiff{Op: DiffOpAdded, Actual: actual[j]})
+			grade.Added++
+			j++
+		}
+	}
+
+	if n > 0 {
+		grade.Accuracy = max(0, 1-float64(dist[0][0])/float64(n))
+	}
+	return grade
+}
+
+// scriptureWords normalizes text for recitation grading: case, punctuation
+// and whitespace are ignored, and apostrophes are dropped so "name's" and
+// "names" compare equal.
+func scriptureWords(text string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == '\'' || r == '’':
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Fields(b.String())
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"koinonia-backend/models"
+)
+
+func TestGradeScripture(t *testing.T) {
+	const verse = "For God so loved the world"
+
+	tests := []struct {
+		name       string
+		reference  string
+		recitation string
+		accuracy   float64
+		missed     int
+		added      int
+		swapped    int
+	}{
+		{"exact", verse, verse, 1, 0, 0, 0},
+		{"case and punctuation ignored", verse, "for GOD, so loved... the world!", 1, 0, 0, 0},
+		{"apostrophes dropped", "The Lord's prayer", "the lords prayer", 1, 0, 0, 0},
+		{"curly apostrophes dropped", "The Lord’s prayer", "the lord's prayer", 1, 0, 0, 0},
+		{"missed word", verse, "For God loved the world", 5.0 / 6, 1, 0, 0},
+		{"added word", verse, "For God so very loved the world", 5.0 / 6, 0, 1, 0},
+		{"swapped word", verse, "For God so loved the earth", 5.0 / 6, 0, 0, 1},
+		{"empty recitation", verse, "", 0, 6, 0, 0},
+		{"unrelated text clamps at zero", "Jesus wept", "one two three four five", 0, 0, 3, 2},
+		{"empty reference", "", "anything", 0, 0, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grade := gradeScripture(tt.reference, tt.recitation)
+			if math.Abs(grade.Accuracy-tt.accuracy) > 1e-9 {
+				t.Errorf("accuracy = %v, want %v", grade.Accuracy, tt.accuracy)
+			}
+			if grade.Missed != tt.missed || grade.Added != tt.added || grade.Swapped != tt.swapped {
+				t.Errorf("missed/added/swapped = %d/%d/%d, want %d/%d/%d",
+					grade.Missed, grade.Added, grade.Swapped, tt.missed, tt.added, tt.swapped)
+			}
+		})
+	}
+}
+
+func TestGradeScriptureDiff(t *testing.T) {
+	grade := gradeScripture("Jesus wept", "Jesus truly cried")
+	want := []WordDiff{
+		{Op: DiffOpMatch, Expected: "jesus", Actual: "jesus"},
+		{Op: DiffOpSwapped, Expected: "wept", Actual: "truly"},
+		{Op: DiffOpAdded, Actual: "cried"},
+	}
+	if !reflect.DeepEqual(grade.Diff, want) {
+		t.Errorf("diff = %+v, want %+v", grade.Diff, want)
+	}
+}
+
+func TestGradeTrivia(t *testing.T) {
+	options := `["Moses", "Noah", "Abraham"]`
+
+	tests := []struct {
+		name    string
+		options string
+		correct string
+		answer  string
+		want    bool
+		err     error
+	}{
+		{"correct option", options, "Noah", "Noah", true, nil},
+		{"case and spacing ignored", options, "Noah", "  noah ", true, nil},
+		{"wrong option", options, "Noah", "Moses", false, nil},
+		{"not an option", options, "Noah", "Jonah", false, errInvalidTriviaOption},
+		{"blank answer", options, "Noah", "   ", false, errInvalidTriviaOption},
+		{"free text correct", "", "Bethlehem", "bethlehem", true, nil},
+		{"free text wrong", "", "Bethlehem", "Nazareth", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quest := &models.Quest{Type: models.QuestTypeTrivia, TriviaOptions: tt.options, CorrectAnswer: tt.correct}
+			got, err := gradeTrivia(quest, tt.answer)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("correct = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGradeTriviaMalformedOptions(t *testing.T) {
+	quest := &models.Quest{Type: models.QuestTypeTrivia, TriviaOptions: "Moses, Noah", CorrectAnswer: "Noah"}
+	if _, err := gradeTrivia(quest, "Noah"); err == nil {
+		t.Error("expected an error for options that aren't a JSON array")
+	}
+}
+
+func TestGradeSubmission(t *testing.T) {
+	h := &Handler{cfg: Config{ScriptureApproveThreshold: 0.9}}
+
+	tests := []struct {
+		name    string
+		quest   models.Quest
+		content string
+		want    models.SubmissionStatus
+	}{
+		{"trivia correct", models.Quest{Type: models.QuestTypeTrivia, CorrectAnswer: "Noah"}, "noah", models.SubmissionStatusApproved},
+		{"trivia incorrect", models.Quest{Type: models.QuestTypeTrivia, CorrectAnswer: "Noah"}, "Moses", models.SubmissionStatusRejected},
+		{"trivia without answer", models.Quest{Type: models.QuestTypeTrivia}, "Noah", models.SubmissionStatusPending},
+		{"scripture above threshold", models.Quest{Type: models.QuestTypeScripture, ScriptureText: "Jesus wept"}, "Jesus wept.", models.SubmissionStatusApproved},
+		{"scripture below threshold", models.Quest{Type: models.QuestTypeScripture, ScriptureText: "Jesus wept"}, "Jesus cried", models.SubmissionStatusPending},
+		{"side quest", models.Quest{Type: models.QuestTypeSideQuest}, "Done", models.SubmissionStatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			submission := &models.Submission{Content: tt.content}
+			status, _, err := h.gradeSubmission(&tt.quest, submission)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.want {
+				t.Errorf("status = %q, want %q", status, tt.want)
+			}
+			if tt.quest.Type == models.QuestTypeScripture && submission.Accuracy == nil {
+				t.Error("scripture grading didn't record accuracy")
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// inviteCodeAlphabet leaves out characters that are easily misread
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Reasons a registration is refused by the invite and email domain gates
+var (
+	errInviteInvalid         = errors.New("invite code is invalid, expired or used up")
+	errInviteRequired        = errors.New("an invite code is required")
+	errEmailDomainNotAllowed = errors.New("email domain is not allowed")
+)
+
+// RegistrationPolicy tells the sign-up page what registration requires
+type RegistrationPolicy struct {
+	InviteRequired      bool     `json:"invite_required"`       // A code is needed unless the email domain is allowed
+	AllowedEmailDomains []string `json:"allowed_email_domains"` // Empty when any domain may register
+}
+
+// Registration Handlers
+
+// GetRegistrationPolicy returns whether registering needs an invite code
+// or an email at particular domains
+func (h *Handler) GetRegistrationPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := RegistrationPolicy{
+		InviteRequired:      h.cfg.RequireInviteCode,
+		AllowedEmailDomains: h.cfg.AllowedEmailDomains,
+	}
+	if policy.AllowedEmailDomains == nil {
+		policy.AllowedEmailDomains = []string{}
+	}
+
+	writeJSON(w, policy, http.StatusOK)
+}
+
+// Invite Code Handlers
+
+// GetInviteCodes lists invite codes, newest first
+func (h *Handler) GetInviteCodes(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r, 50, 200)
+
+	var invites []models.InviteCode
+	if err := h.db.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&invites).Error; err != nil {
+		writeJSONError(w, "Failed to fetch invite codes", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, invites, http.StatusOK)
+}
+
+// CreateInviteCode creates an invite code. The code is generated unless one
+// is given; max_uses, expires_at and role are optional.
+func (h *Handler) CreateInviteCode(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(uint)
+
+	var req struct {
+		Code      string     `json:"code"`
+		Note      string     `json:"note"`
+		Role      string     `json:"role"`
+		MaxUses   int        `json:"max_uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if _, ok := rolePermissions[req.Role]; !ok {
+		writeJSONError(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	// An invite is shared as a plain code, so it must not be a way to hand
+	// out more access than its creator has
+	creatorRole, _ := r.Context().Value("user_role").(string)
+	if !roleWithin(req.Role, creatorRole) {
+		writeJSONError(w, "Invites can't grant the admin role or permissions you don't have", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		writeJSONError(w, "max_uses cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		writeJSONError(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	code := normalizeInviteCode(req.Code)
+	if code == "" {
+		var err error
+		if code, err = generateInviteCode(); err != nil {
+			writeJSONError(w, "Failed to create invite code", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	invite := models.InviteCode{
+		Code:        code,
+		Note:        strings.TrimSpace(req.Note),
+		Role:        req.Role,
+		MaxUses:     req.MaxUses,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedByID: &adminID,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.InviteCode{}).Where("code = ?", code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return gorm.ErrDuplicatedKey
+		}
+		if err := tx.Create(&invite).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditInviteCreate, auditTargetInvite, invite.ID, nil, invite)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeJSONError(w, "Invite code already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to create invite code", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, invite, http.StatusCreated)
+}
+
+// RevokeInviteCode stops an invite code from admitting anyone else. Users
+// who already registered with it are unaffected.
+func (h *Handler) RevokeInviteCode(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid invite code ID", http.StatusBadRequest)
+		return
+	}
+
+	var invite models.InviteCode
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&invite, inviteID).Error; err != nil {
+			return err
+		}
+		if invite.RevokedAt != nil {
+			return nil
+		}
+		before := invite
+		now := time.Now()
+		if err := tx.Model(&invite).Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditInviteRevoke, auditTargetInvite, invite.ID, before, invite)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeJSONError(w, "Invite code not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to revoke invite code", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, invite, http.StatusOK)
+}
+
+// admitRegistration decides whether an account may be created for email.
+// A given invite code must be valid and is used up by one; without one, the
+// email must be at an allowed domain when registration is gated. It returns
+// the invite used, if any.
+func (h *Handler) admitRegistration(tx *gorm.DB, email, code string) (*models.InviteCode, error) {
+	if code = normalizeInviteCode(code); code != "" {
+		return useInviteCode(tx, code)
+	}
+
+	if len(h.cfg.AllowedEmailDomains) > 0 && h.emailDomainAllowed(email) {
+		return nil, nil
+	}
+	if h.cfg.RequireInviteCode {
+		return nil, errInviteRequired
+	}
+	if len(h.cfg.AllowedEmailDomains) > 0 {
+		return nil, errEmailDomainNotAllowed
+	}
+	return nil, nil
+}
+
+// emailDomainAllowed reports whether email is at an allowed domain or one
+// of its subdomains
+func (h *Handler) emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	for _, allowed := range h.cfg.AllowedEmailDomains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRegistrationError reports why a registration was refused
+func writeRegistrationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInviteInvalid):
+		writeJSONErrorCode(w, "Invite code is invalid or has expired", "invalid_invite", http.StatusBadRequest)
+	case errors.Is(err, errInviteRequired):
+		writeJSONErrorCode(w, "An invite code is required to register", "invite_required", http.StatusForbidden)
+	case errors.Is(err, errEmailDomainNotAllowed):
+		writeJSONErrorCode(w, "Registration is limited to approved email domains", "email_domain_not_allowed", http.StatusForbidden)
+	default:
+		writeJSONError(w, "Failed to create user", http.StatusInternalServerError)
+	}
+}
+
+// useInviteCode counts a use of an invite code if it is still valid. The
+// conditional update keeps concurrent registrations within max_uses.
+func useInviteCode(tx *gorm.DB, code string) (*models.InviteCode, error) {
+	result := tx.Model(&models.InviteCode{}).
+		Where("code = ? AND revoked_at IS NULL", code).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_uses = 0 OR uses < max_uses").
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInviteInvalid
+	}
+
+	var invite models.InviteCode
+	if err := tx.Where("code = ?", code).First(&invite).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// normalizeInviteCode makes codes case-insensitive
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// generateInviteCode returns a random code like "K7QM-2XPD"
+func generateInviteCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(inviteCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKeys generates an Ed25519 and an RSA key pair
+func testKeys(t *testing.T) (ed25519.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edKey, rsaKey
+}
+
+// pkcs8PEM encodes a private key as PEM
+func pkcs8PEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// pkixPEM encodes a public key as PEM
+func pkixPEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// writeKeyFile writes a key set file and returns its path
+func writeKeyFile(t *testing.T, file interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verify parses a token against a key set
+func verify(ks *KeySet, token string) error {
+	_, err := jwt.Parse(token, ks.keyFunc)
+	return err
+}
+
+func TestKeySetRotation(t *testing.T) {
+	edKey, rsaKey := testKeys(t)
+	edPrivate := pkcs8PEM(t, edKey)
+	rsaPrivate := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	rsaPublic := pkixPEM(t, &rsaKey.PublicKey)
+	claims := jwt.RegisteredClaims{Subject: "1"}
+
+	// Before rotation: tokens are signed with the RSA key
+	before, err := LoadKeySetFile(writeKeyFile(t, map[string]interface{}{
+		"signing_key": "old",
+		"keys": []map[string]string{
+			{"kid": "old", "alg": "RS256", "private_key": rsaPrivate},
+			{"kid": "legacy", "alg": "HS256", "secret": "legacy-secret"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	legacy.Header["kid"] = "legacy"
+	legacyToken, _ := legacy.SignedString([]byte("legacy-secret"))
+
+	// After rotation: the Ed25519 key signs and the RSA key only verifies
+	after, err := LoadKeySetFile(writeKeyFile(t, map[string]interface{}{
+		"signing_key": "new",
+		"keys": []map[string]string{
+			{"kid": "new", "alg": "EdDSA", "private_key": edPrivate},
+			{"kid": "old", "alg": "RS256", "public_key": rsaPublic},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := after.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ks    *KeySet
+		token string
+		ok    bool
+	}{
+		{"old token before rotation", before, oldToken, true},
+		{"legacy secret before rotation", before, legacyToken, true},
+		{"old token after rotation", after, oldToken, true},
+		{"new token after rotation", after, newToken, true},
+		{"new token on old servers", before, newToken, false},
+		{"removed legacy secret", after, legacyToken, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.ks, tt.token)
+			if (err == nil) != tt.ok {
+				t.Errorf("verify err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if parsed.Header["kid"] != "new" || parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("new token header = %v", parsed.Header)
+	}
+}
+
+func TestKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	_, rsaKey := testKeys(t)
+	ks, err := NewKeySet("rsa", &SigningKey{ID: "rsa", Method: jwt.SigningMethodRS256, Sign: rsaKey, Verify: &rsaKey.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HS256 token naming the RSA key must not be verified with it
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	forged.Header["kid"] = "rsa"
+	token, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	if err := verify(ks, token); err == nil {
+		t.Error("token with a mismatched algorithm was accepted")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{Subject: "1"})
+	unknown.Header["kid"] = "other"
+	token, _ = unknown.SignedString(rsaKey)
+	if err := verify(ks, token); err == nil {
+		t.Error("token with an unknown kid was accepted")
+	}
+}
+
+func TestNewKeySetErrors(t *testing.T) {
+	edKey, _ := testKeys(t)
+	signer := &SigningKey{ID: "a", Method: jwt.SigningMethodEdDSA, Sign: edKey, Verify: edKey.Public()}
+	verifyOnly := &SigningKey{ID: "b", Method: jwt.SigningMethodEdDSA, Verify: edKey.Public()}
+
+	tests := []struct {
+		name      string
+		signingID string
+		keys      []*SigningKey
+	}{
+		{"missing signing key", "c", []*SigningKey{signer}},
+		{"verify-only signing key", "b", []*SigningKey{signer, verifyOnly}},
+		{"duplicate IDs", "a", []*SigningKey{signer, signer}},
+		{"empty ID", "a", []*SigningKey{signer, {Method: jwt.SigningMethodHS256, Verify: []byte("x")}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewKeySet(tt.signingID, tt.keys...); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	if _, err := NewHMACKeySet("default", ""); err == nil {
+		t.Error("expected an error for an empty secret")
+	}
+}
+
+func TestLoadKeySetFileErrors(t *testing.T) {
+	edKey, _ := testKeys(t)
+	edPrivate := pkcs8PEM(t, edKey)
+
+	tests := []struct {
+		name string
+		key  map[string]string
+	}{
+		{"unsupported algorithm", map[string]string{"kid": "a", "alg": "none", "secret": "x"}},
+		{"HS256 without secret", map[string]string{"kid": "a", "alg": "HS256"}},
+		{"no key material", map[string]string{"kid": "a", "alg": "EdDSA"}},
+		{"invalid PEM", map[string]string{"kid": "a", "alg": "EdDSA", "private_key": "not pem"}},
+		{"key type mismatch", map[string]string{"kid": "a", "alg": "RS256", "private_key": edPrivate}},
+	}
+
+	for _, tt := range tests {
+		path := writeKeyFile(t, map[string]interface{}{"signing_key": "a", "keys": []map[string]string{tt.key}})
+		if _, err := LoadKeySetFile(path); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestGetJWKS(t *testing.T) {
+	edKey, rsaKey := testKeys(t)
+	ks, err := NewKeySet("ed",
+		&SigningKey{ID: "ed", Method: jwt.SigningMethodEdDSA, Sign: edKey, Verify: edKey.Public()},
+		&SigningKey{ID: "rsa", Method: jwt.SigningMethodRS256, Verify: &rsaKey.PublicKey},
+		&SigningKey{ID: "secret", Method: jwt.SigningMethodHS256, Sign: []byte("x"), Verify: []byte("x")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{cfg: Config{Keys: ks}}
+	w := httptest.NewRecorder()
+	h.GetJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	var response struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Keys) != 2 {
+		t.Fatalf("published %d keys, want 2 without the HMAC secret: %+v", len(response.Keys), response.Keys)
+	}
+
+	ed, rsaJWK := response.Keys[0], response.Keys[1]
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" ||
+		ed.X != base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	if rsaJWK.Kid != "rsa" || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" ||
+		new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Errorf("RSA JWK = %+v", rsaJWK)
+	}
+}
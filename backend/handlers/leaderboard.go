@@ -1,53 +1,228 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
 
 	"koinonia-backend/models"
 )
 
-// GetLeaderboard returns the top users ranked by total points
+// Leaderboard periods accepted by the period query parameter
+const (
+	LeaderboardPeriodWeek   = "week"
+	LeaderboardPeriodMonth  = "month"
+	LeaderboardPeriodSeason = "season"
+	LeaderboardPeriodAll    = "all"
+)
+
+// leaderboardMaxLimit is the most entries GetLeaderboard returns at once
+const leaderboardMaxLimit = 100
+
+// leaderboardWindow is the time range a leaderboard covers. A nil bound is
+// open-ended; with both nil the board ranks lifetime total points.
+type leaderboardWindow struct {
+	From *time.Time
+	To   *time.Time
+}
+
+// GetLeaderboard returns the top users ranked by points, either lifetime or
+// earned within a period (?period=week|month|season|all or ?from=&to=).
+// With ?dense=true tied users share a rank.
 func (h *Handler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
 	// Query parameters
-	limit := 10 // Default limit
-	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
-		if parsedLimit, err := parseID(r, "limit"); err == nil && parsedLimit > 0 {
-			limit = int(parsedLimit)
-		}
+	limit := queryInt(r, "limit", 10)
+	if limit <= 0 {
+		limit = 10
+	}
+	limit = min(limit, leaderboardMaxLimit)
+
+	window, err := h.parseLeaderboardWindow(r, time.Now())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Get users with their quest completion counts
 	var leaderboard []models.LeaderboardEntry
+	if err := h.rankedLeaderboard(window, queryBool(r, "dense")).Order("row_num").Limit(limit).Scan(&leaderboard).Error; err != nil {
+		writeJSONError(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, leaderboard, http.StatusOK)
+}
+
+// MyRankResponse is the caller's leaderboard entry with its neighbors
+type MyRankResponse struct {
+	Me    models.LeaderboardEntry   `json:"me"`
+	Above []models.LeaderboardEntry `json:"above"` // In rank order, ending just above the caller
+	Below []models.LeaderboardEntry `json:"below"`
+}
+
+// GetMyRank returns the caller's leaderboard entry plus up to ?neighbors=
+// entries above and below it, using the same periods and ranking as GetLeaderboard
+func (h *Handler) GetMyRank(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	neighbors := min(queryInt(r, "neighbors", 3), 25)
+
+	window, err := h.parseLeaderboardWindow(r, time.Now())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	board := h.rankedLeaderboard(window, queryBool(r, "dense")).Session(&gorm.Session{})
+
+	var me struct {
+		models.LeaderboardEntry
+		RowNum int
+	}
+	if err := board.Where("user_id = ?", userID).Scan(&me).Error; err != nil {
+		writeJSONError(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
+		return
+	}
+	if me.RowNum == 0 {
+		writeJSONError(w, "You are not on the leaderboard", http.StatusNotFound)
+		return
+	}
 
-	// Complex query to get users with quest completion counts
-	query := `
-		SELECT 
+	response := MyRankResponse{
+		Me:    me.LeaderboardEntry,
+		Above: []models.LeaderboardEntry{},
+		Below: []models.LeaderboardEntry{},
+	}
+	if neighbors > 0 {
+		if err := board.Where("row_num >= ? AND row_num < ?", me.RowNum-neighbors, me.RowNum).
+			Order("row_num").Scan(&response.Above).Error; err != nil {
+			writeJSONError(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
+			return
+		}
+		if err := board.Where("row_num > ? AND row_num <= ?", me.RowNum, me.RowNum+neighbors).
+			Order("row_num").Scan(&response.Below).Error; err != nil {
+			writeJSONError(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// rankedLeaderboard returns a query over every active, verified user's leaderboard
+// entry for the window. Rows are ordered by points with earlier sign-ups
+// winning ties; row_num is the row's place in that order, and rank equals
+// row_num unless dense is set, in which case tied users share a rank.
+func (h *Handler) rankedLeaderboard(window leaderboardWindow, dense bool) *gorm.DB {
+	// Lifetime boards use the cached balance; windowed boards sum the
+	// ledger inside the window, so bonuses and adjustments count too
+	pointsExpr := "u.total_points"
+	pointsJoin := ""
+	submissionConditions, submissionArgs := window.conditions("reviewed_at")
+	submissionConditions = append([]string{"status = 'approved'"}, submissionConditions...)
+	args := submissionArgs
+	if window.From != nil || window.To != nil {
+		// Opening balances were earned before the ledger existed, whenever
+		// they happen to be recorded
+		ledgerConditions, ledgerArgs := window.conditions("created_at")
+		ledgerConditions = append([]string{"reason <> ?"}, ledgerConditions...)
+		args = append(args, models.PointReasonOpeningBalance)
+		args = append(args, ledgerArgs...)
+
+		pointsExpr = "COALESCE(p.points, 0)"
+		pointsJoin = `
+		LEFT JOIN (
+			SELECT user_id, SUM(amount) as points
+			FROM point_transactions
+			WHERE ` + strings.Join(ledgerConditions, " AND ") + `
+			GROUP BY user_id
+		) p ON u.id = p.user_id`
+	}
+
+	rankExpr := "ROW_NUMBER() OVER (ORDER BY %[1]s DESC, u.created_at ASC)"
+	if dense {
+		rankExpr = "DENSE_RANK() OVER (ORDER BY %[1]s DESC)"
+	}
+
+	// Query to get users with points and quest completion counts
+	query := fmt.Sprintf(`
+		SELECT
 			u.id as user_id,
 			u.username,
 			u.first_name,
 			u.last_name,
 			u.avatar,
-			u.total_points,
+			%[1]s as total_points,
 			COALESCE(s.quests_completed, 0) as quests_completed,
-			ROW_NUMBER() OVER (ORDER BY u.total_points DESC, u.created_at ASC) as rank
+			ROW_NUMBER() OVER (ORDER BY %[1]s DESC, u.created_at ASC) as row_num,
+			`+rankExpr+` as rank
 		FROM users u
 		LEFT JOIN (
-			SELECT 
-				user_id, 
+			SELECT
+				user_id,
 				COUNT(*) as quests_completed
-			FROM submissions 
-			WHERE status = 'approved'
+			FROM submissions
+			WHERE %[2]s
 			GROUP BY user_id
-		) s ON u.id = s.user_id
-		WHERE u.is_active = true
-		ORDER BY u.total_points DESC, u.created_at ASC
-		LIMIT ?
-	`
+		) s ON u.id = s.user_id%[3]s
+		WHERE u.is_active = true AND u.email_verified_at IS NOT NULL
+	`, pointsExpr, strings.Join(submissionConditions, " AND "), pointsJoin)
 
-	if err := h.db.Raw(query, limit).Scan(&leaderboard).Error; err != nil {
-		writeJSONError(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
-		return
+	return h.db.Table("(?) as board", h.db.Raw(query, args...))
+}
+
+// conditions returns SQL conditions limiting column to the window
+func (w leaderboardWindow) conditions(column string) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if w.From != nil {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, *w.From)
+	}
+	if w.To != nil {
+		conditions = append(conditions, column+" < ?")
+		args = append(args, *w.To)
 	}
+	return conditions, args
+}
 
-	writeJSON(w, leaderboard, http.StatusOK)
+// parseLeaderboardWindow resolves the period or from/to query parameters
+// into a time window relative to now
+func (h *Handler) parseLeaderboardWindow(r *http.Request, now time.Time) (leaderboardWindow, error) {
+	var window leaderboardWindow
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from != "" || to != "" {
+		var err error
+		if window.From, err = parseTimeParam(from); err != nil {
+			return window, errors.New("Invalid from date")
+		}
+		if window.To, err = parseTimeParam(to); err != nil {
+			return window, errors.New("Invalid to date")
+		}
+		return window, nil
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch r.URL.Query().Get("period") {
+	case "", LeaderboardPeriodAll:
+		// Lifetime totals
+	case LeaderboardPeriodWeek:
+		// Weeks start on Monday
+		start := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		window.From = &start
+	case LeaderboardPeriodMonth:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		window.From = &start
+	case LeaderboardPeriodSeason:
+		if h.cfg.SeasonStart == nil {
+			return window, errors.New("No season is configured")
+		}
+		window.From, window.To = h.cfg.SeasonStart, h.cfg.SeasonEnd
+	default:
+		return window, errors.New("Period must be one of week, month, season or all")
+	}
+
+	return window, nil
 }
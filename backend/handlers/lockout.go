@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"koinonia-backend/lockout"
+	"koinonia-backend/models"
+)
+
+// dummyPasswordHash is compared against when a login names no account, so
+// unknown usernames take as long to reject as wrong passwords
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// accountLockoutKey keys failures by user ID, so logging in by username and
+// by email share a counter
+func accountLockoutKey(userID uint) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// unknownLockoutKey keys failures for names that match no account, so they
+// are throttled exactly like real accounts
+func unknownLockoutKey(name string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// twoFactorLockoutKey keys failed two-factor codes by user ID
+func twoFactorLockoutKey(userID uint) string {
+	return "2fa:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// ipLockoutKey keys failures by client IP address
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// passwordResetLockoutKey keys password reset requests by client IP, apart
+// from failed logins so one doesn't throttle the other
+func passwordResetLockoutKey(ip string) string {
+	return "reset-ip:" + ip
+}
+
+// Lockout Handlers
+
+// UnlockUser lets admins clear an account's failed login and two-factor
+// attempts so the user can log in again immediately
+func (h *Handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(uint)
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	for _, key := range []string{accountLockoutKey(user.ID), twoFactorLockoutKey(user.ID)} {
+		if err := h.cfg.AccountLockout.Reset(r.Context(), key); err != nil {
+			writeJSONError(w, "Failed to unlock account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&models.LockoutEvent{
+			AttemptKey: accountLockoutKey(user.ID),
+			Action:     models.LockoutActionUnlocked,
+			UserID:     &user.ID,
+			IP:         clientIP(r),
+			AdminID:    &adminID,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditUserUnlock, auditTargetUser, user.ID, nil, nil)
+	})
+	if err != nil {
+		writeJSONError(w, "Failed to record unlock", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Account unlocked"}, http.StatusOK)
+}
+
+// loginAttempt is an attempt counted against an account key and the
+// client's IP before it is verified
+type loginAttempt struct {
+	accountKey string
+	ip         string
+	account    lockout.Result // State of the account key should the attempt fail
+	client     lockout.Result // State of the IP key should the attempt fail
+}
+
+// reserveLoginAttempt counts an attempt on accountKey and the client's IP
+// before the credentials are checked, so concurrent guesses can't all slip
+// past the backoff. If either key is throttled, nothing is counted and the
+// wait is returned instead.
+func (h *Handler) reserveLoginAttempt(r *http.Request, accountKey string) (*loginAttempt, time.Duration, error) {
+	attempt := &loginAttempt{accountKey: accountKey, ip: clientIP(r)}
+
+	wait, result, err := h.cfg.IPLockout.Reserve(r.Context(), ipLockoutKey(attempt.ip))
+	if err != nil || wait > 0 {
+		return nil, wait, err
+	}
+	attempt.client = result
+
+	wait, result, err = h.cfg.AccountLockout.Reserve(r.Context(), accountKey)
+	if err != nil || wait > 0 {
+		if err := h.cfg.IPLockout.Release(r.Context(), ipLockoutKey(attempt.ip)); err != nil {
+			log.Printf("Failed to release login attempt from %s: %v", attempt.ip, err)
+		}
+		return nil, wait, err
+	}
+	attempt.account = result
+	return attempt, 0, nil
+}
+
+// failed keeps the attempt counted, recording a lockout event when it
+// locked out the account or the client's IP
+func (a *loginAttempt) failed(h *Handler, r *http.Request, userID *uint) {
+	if a.account.Locked {
+		h.recordLockout(r.Context(), a.accountKey, userID, a.ip, a.account.Failures, a.account.LockedTill)
+	}
+	if a.client.Locked {
+		h.recordLockout(r.Context(), ipLockoutKey(a.ip), nil, a.ip, a.client.Failures, a.client.LockedTill)
+	}
+}
+
+// succeeded clears the account's failures and takes back the attempt
+// counted against the client's IP, which may be shared with others
+func (a *loginAttempt) succeeded(h *Handler, r *http.Request) {
+	if err := h.cfg.AccountLockout.Reset(r.Context(), a.accountKey); err != nil {
+		log.Printf("Failed to reset login attempts for %s: %v", a.accountKey, err)
+	}
+	if err := h.cfg.IPLockout.Release(r.Context(), ipLockoutKey(a.ip)); err != nil {
+		log.Printf("Failed to release login attempt from %s: %v", a.ip, err)
+	}
+}
+
+// recordLockout saves a lockout event
+func (h *Handler) recordLockout(ctx context.Context, key string, userID *uint, ip string, failures int, until time.Time) {
+	err := h.db.WithContext(ctx).Create(&models.LockoutEvent{
+		AttemptKey:  key,
+		Action:      models.LockoutActionLocked,
+		UserID:      userID,
+		IP:          ip,
+		Failures:    failures,
+		LockedUntil: &until,
+	}).Error
+	if err != nil {
+		log.Printf("Failed to record lockout of %s: %v", key, err)
+	}
+}
+
+// writeTooManyAttempts rejects a throttled attempt without saying whether
+// the account exists
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	writeJSONError(w, "Too many attempts, please try again later", http.StatusTooManyRequests)
+}
+
+// compareDummyPassword spends the time a real password check would take
+func compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
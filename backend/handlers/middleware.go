@@ -28,9 +28,7 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 		tokenString := parts[1]
 
 		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, h.cfg.Keys.keyFunc)
 
 		if err != nil {
 			writeJSONError(w, "Invalid token", http.StatusUnauthorized)
@@ -44,22 +42,69 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Reject tokens whose session was revoked or whose user was deactivated
+		session, err := h.checkSession(claims.SessionID, claims.UserID)
+		if err != nil {
+			writeJSONError(w, "Failed to verify session", http.StatusInternalServerError)
+			return
+		}
+		if !session.Active {
+			writeJSONError(w, "Session has been revoked", http.StatusUnauthorized)
+			return
+		}
+
 		// Add user info to request context
 		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-		ctx = context.WithValue(ctx, "user_role", claims.Role)
+		ctx = context.WithValue(ctx, "user_role", session.Role)
+		ctx = context.WithValue(ctx, "session_id", claims.SessionID)
+		ctx = context.WithValue(ctx, "email_verified", session.EmailVerified)
+		ctx = context.WithValue(ctx, "two_factor_enabled", session.TwoFactorEnabled)
+		ctx = context.WithValue(ctx, "two_factor_login", session.TwoFactorLogin)
 
 		// Call next handler with updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// AdminMiddleware ensures the user has admin role
-func (h *Handler) AdminMiddleware(next http.Handler) http.Handler {
+// RequirePermission ensures the user's role grants every given permission,
+// and, when policy requires two-factor authentication for staff, that this
+// session's login used it. Enabling it later doesn't upgrade sessions that
+// were started with only a password.
+func (h *Handler) RequirePermission(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, perm := range perms {
+				if !hasPermission(r, perm) {
+					writeJSONErrorCode(w, "You don't have permission to do that", "forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			if h.cfg.RequireAdminTwoFactor {
+				enabled, _ := r.Context().Value("two_factor_enabled").(bool)
+				login, _ := r.Context().Value("two_factor_login").(bool)
+				if !enabled {
+					writeJSONErrorCode(w, "Enable two-factor authentication to use staff features", "two_factor_required", http.StatusForbidden)
+					return
+				}
+				if !login {
+					writeJSONErrorCode(w, "Log in again with your two-factor code to use staff features", "two_factor_login_required", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// VerifiedEmailMiddleware ensures the user has verified their email address
+func (h *Handler) VerifiedEmailMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		role := r.Context().Value("user_role").(string)
-		
-		if role != "admin" {
-			writeJSONError(w, "Admin access required", http.StatusForbidden)
+		verified, _ := r.Context().Value("email_verified").(bool)
+
+		if !verified {
+			writeJSONErrorCode(w, "Please verify your email address first", "email_unverified", http.StatusForbidden)
 			return
 		}
 
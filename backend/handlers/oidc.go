@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+	"koinonia-backend/oidc"
+)
+
+// oidcStateTTL is how long a user has to finish logging in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// Error codes passed back to the frontend when single sign-on fails
+const (
+	oidcErrorProvider    = "provider_error"
+	oidcErrorState       = "invalid_state"
+	oidcErrorUnverified  = "email_unverified"
+	oidcErrorDeactivated = "account_deactivated"
+	oidcErrorFailed      = "login_failed"
+	oidcErrorInvite      = "invalid_invite"
+	oidcErrorNoInvite    = "invite_required"
+	oidcErrorDomain      = "email_domain_not_allowed"
+	oidcErrorUnlinkable  = "account_unverified"
+)
+
+var (
+	errOIDCEmailUnverified = errors.New("provider did not return a verified email")
+	// errOIDCAccountUnverified is returned when the provider's email matches
+	// an account whose owner never verified the address. Whoever registered
+	// it may not own the address, so linking would let them keep access
+	// through the account's password.
+	errOIDCAccountUnverified = errors.New("matching account has an unverified email")
+)
+
+// OIDCProviderInfo describes a single sign-on option for the login page
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+// Single Sign-On Handlers
+
+// GetOIDCProviders lists the configured single sign-on providers
+func (h *Handler) GetOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]OIDCProviderInfo, 0, len(h.cfg.OIDCProviders))
+	for _, p := range h.cfg.OIDCProviders {
+		cfg := p.Config()
+		displayName := cfg.DisplayName
+		if displayName == "" {
+			displayName = cfg.Name
+		}
+		providers = append(providers, OIDCProviderInfo{
+			Name:        cfg.Name,
+			DisplayName: displayName,
+			LoginURL:    "/api/auth/oidc/" + url.PathEscape(cfg.Name) + "/login",
+		})
+	}
+
+	writeJSON(w, providers, http.StatusOK)
+}
+
+// OIDCLogin starts an authorization-code login by redirecting the user to
+// the provider with a fresh state, nonce and PKCE challenge. An invite_code
+// query parameter is kept for registering if the login creates an account.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := h.oidcProvider(chi.URLParam(r, "provider"))
+	if provider == nil {
+		writeJSONError(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		token, err := randomToken(32)
+		if err != nil {
+			writeJSONError(w, "Failed to start login", http.StatusInternalServerError)
+			return
+		}
+		values[i] = token
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	// Clear out logins that were abandoned at the provider
+	h.db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{})
+
+	login := models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider.Config().Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		InviteCode:   normalizeInviteCode(r.URL.Query().Get("invite_code")),
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := h.db.Create(&login).Error; err != nil {
+		writeJSONError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", login.Provider, err)
+		writeJSONError(w, "Login provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes a login when the provider redirects back. The user
+// is sent on to the frontend with tokens, a two-factor challenge or an error
+// code in the URL fragment, so they never reach server logs.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := h.oidcProvider(chi.URLParam(r, "provider"))
+	if provider == nil {
+		writeJSONError(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+	name := provider.Config().Name
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		h.redirectOIDCError(w, r, oidcErrorProvider)
+		return
+	}
+
+	// The state is single-use: deleting it claims it, so a replayed
+	// callback finds nothing
+	var login models.OIDCLoginState
+	err := h.db.Where("state_hash = ? AND provider = ?", hashToken(query.Get("state")), name).
+		First(&login).Error
+	if err != nil {
+		h.redirectOIDCError(w, r, oidcErrorState)
+		return
+	}
+	result := h.db.Delete(&login)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(login.ExpiresAt) {
+		h.redirectOIDCError(w, r, oidcErrorState)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", name, err)
+		h.redirectOIDCError(w, r, oidcErrorProvider)
+		return
+	}
+
+	user, err := h.findOrCreateOIDCUser(provider, claims, login.InviteCode)
+	if errors.Is(err, errOIDCEmailUnverified) {
+		h.redirectOIDCError(w, r, oidcErrorUnverified)
+		return
+	}
+	if errors.Is(err, errOIDCAccountUnverified) {
+		h.redirectOIDCError(w, r, oidcErrorUnlinkable)
+		return
+	}
+	if errors.Is(err, errInviteInvalid) {
+		h.redirectOIDCError(w, r, oidcErrorInvite)
+		return
+	}
+	if errors.Is(err, errInviteRequired) {
+		h.redirectOIDCError(w, r, oidcErrorNoInvite)
+		return
+	}
+	if errors.Is(err, errEmailDomainNotAllowed) {
+		h.redirectOIDCError(w, r, oidcErrorDomain)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", name, err)
+		h.redirectOIDCError(w, r, oidcErrorFailed)
+		return
+	}
+
+	if !user.IsActive {
+		h.redirectOIDCError(w, r, oidcErrorDeactivated)
+		return
+	}
+
+	// Single sign-on doesn't skip the user's own second factor
+	if user.TwoFactorEnabledAt != nil {
+		challenge, err := h.twoFactorChallenge(user)
+		if err != nil {
+			h.redirectOIDCError(w, r, oidcErrorFailed)
+			return
+		}
+		fragment := url.Values{"two_factor_token": {challenge}}
+		http.Redirect(w, r, h.cfg.AppURL+"/oidc/callback#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	// Update last login
+	now := time.Now()
+	h.db.Model(user).Update("last_login", &now)
+
+	// Start a session and generate the same tokens as a password login
+	response, err := h.createSession(user, r, models.LoginMethodOIDC)
+	if err != nil {
+		h.redirectOIDCError(w, r, oidcErrorFailed)
+		return
+	}
+
+	fragment := url.Values{"token": {response.Token}, "refresh_token": {response.RefreshToken}}
+	http.Redirect(w, r, h.cfg.AppURL+"/oidc/callback#"+fragment.Encode(), http.StatusFound)
+}
+
+// findOrCreateOIDCUser returns the user linked to the provider account.
+// Accounts are linked to an existing user whose email both sides have
+// verified, or a new user is created on first login, subject to the same
+// invite and email domain gates as registration.
+func (h *Handler) findOrCreateOIDCUser(provider *oidc.Provider, claims *oidc.Claims, inviteCode string) (*models.User, error) {
+	name := provider.Config().Name
+	emailVerified := provider.EmailVerified(claims)
+
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Returning user
+		var identity models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", name, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.First(&user, identity.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&identity).Update("email", claims.Email).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Only an email the provider vouches for may link or create an account,
+		// otherwise anyone could claim an address at a lax provider
+		if !emailVerified {
+			return errOIDCEmailUnverified
+		}
+
+		err = tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&user).Error
+		switch {
+		case err == nil:
+			if user.EmailVerifiedAt == nil {
+				return errOIDCAccountUnverified
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			invite, err := h.admitRegistration(tx, claims.Email, inviteCode)
+			if err != nil {
+				return err
+			}
+			if err := createOIDCUser(tx, &user, claims, invite); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// createOIDCUser creates a verified user from provider claims. The account
+// gets a random password, so it can only log in through single sign-on
+// until the user sets one with a password reset. invite, if set, is the
+// invite code the account was admitted with.
+func createOIDCUser(tx *gorm.DB, user *models.User, claims *oidc.Claims, invite *models.InviteCode) error {
+	password, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	username, err := uniqueUsername(tx, claims.Email)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	*user = models.User{
+		Username:        username,
+		Email:           claims.Email,
+		Password:        string(hashedPassword),
+		FirstName:       claims.GivenName,
+		LastName:        claims.FamilyName,
+		Avatar:          claims.Picture,
+		Role:            models.RoleUser,
+		IsActive:        true,
+		EmailVerifiedAt: &now,
+	}
+	if invite != nil {
+		user.Role = invite.Role
+		user.InviteCodeID = &invite.ID
+	}
+	return tx.Create(user).Error
+}
+
+// uniqueUsername derives an unused username from the local part of an email
+func uniqueUsername(tx *gorm.DB, email string) (string, error) {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	base := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, local)
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Unscoped().Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%04d", base, rand.Intn(10000))
+	}
+	return "", errors.New("could not find a free username")
+}
+
+// oidcProvider finds a configured provider by name
+func (h *Handler) oidcProvider(name string) *oidc.Provider {
+	for _, p := range h.cfg.OIDCProviders {
+		if p.Config().Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// redirectOIDCError sends the user back to the frontend with an error code
+func (h *Handler) redirectOIDCError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, h.cfg.AppURL+"/oidc/callback#error="+url.QueryEscape(code), http.StatusFound)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"koinonia-backend/mail"
+	"koinonia-backend/models"
+)
+
+// errResetTokenInvalid is returned for unknown, expired or used reset tokens
+var errResetTokenInvalid = errors.New("reset token is invalid")
+
+// Password Reset Handlers
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the account exists, so it can't be used to probe for users.
+// Requests are throttled per client IP, and an account that was sent a link
+// within the resend interval isn't sent another until it passes.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		writeJSONError(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Every request counts, whether or not the email matches an account
+	wait, _, err := h.cfg.IPLockout.Reserve(r.Context(), passwordResetLockoutKey(clientIP(r)))
+	if err != nil {
+		writeJSONError(w, "Failed to start password reset", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	response := MessageResponse{Message: "If an account exists for that email, a reset link has been sent"}
+
+	var user models.User
+	if err := h.db.Where("email = ? AND is_active = ?", req.Email, true).First(&user).Error; err != nil {
+		writeJSON(w, response, http.StatusOK)
+		return
+	}
+
+	var token string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent requests can't both pass the check
+		if _, err := lockUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var recent int64
+		err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ? AND created_at > ?",
+				user.ID, now, now.Add(-h.cfg.PasswordResetResendInterval)).
+			Count(&recent).Error
+		if err != nil || recent > 0 {
+			return err
+		}
+
+		token, err = h.createPasswordReset(tx, &user)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, "Failed to start password reset", http.StatusInternalServerError)
+		return
+	}
+	if token != "" {
+		h.emailPasswordReset(&user, token)
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// ResetPassword sets a new password using an emailed reset token. All of
+// the user's sessions are revoked so a compromised login is cut off.
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		writeJSONError(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeJSONError(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Claim the token; the status guard makes it single-use under concurrency
+		now := time.Now()
+		var reset models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", hashToken(req.Token)).First(&reset).Error; err != nil {
+			return errResetTokenInvalid
+		}
+		result := tx.Model(&reset).
+			Where("used_at IS NULL AND expires_at > ?", now).
+			Update("used_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenInvalid
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).
+			Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+
+		// Other outstanding reset links for the account stop working too
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", &now).Error; err != nil {
+			return err
+		}
+
+		return revokeUserSessions(tx, reset.UserID)
+	})
+	if errors.Is(err, errResetTokenInvalid) {
+		writeJSONError(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Password has been reset"}, http.StatusOK)
+}
+
+// createPasswordReset saves a reset token for the user and returns it, so
+// callers inside a transaction can email it once the transaction commits
+func (h *Handler) createPasswordReset(tx *gorm.DB, user *models.User) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	reset := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.cfg.PasswordResetTTL),
+	}
+	if err := tx.Create(&reset).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// emailPasswordReset emails the user a link to use a reset token. The email
+// is sent in the background so response time doesn't reveal whether the
+// account exists.
+func (h *Handler) emailPasswordReset(user *models.User, token string) {
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Koinonia password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s/reset-password?token=%s\n\nIf you didn't ask to reset your password, you can ignore this email.\n",
+			user.Username, h.cfg.PasswordResetTTL, h.cfg.AppURL, url.QueryEscape(token)),
+	}
+	userID := user.ID
+	go func() {
+		if err := h.cfg.Mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", userID, err)
+		}
+	}()
+}
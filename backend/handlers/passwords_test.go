@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"koinonia-backend/lockout"
+	"koinonia-backend/mail"
+)
+
+// resetDB is a stand-in for the tables the password reset handlers use. It
+// answers the handful of statements they send, so the flow can be tested
+// end to end without a Postgres server.
+type resetDB struct {
+	mu       sync.Mutex
+	user     resetUser
+	tokens   []resetToken
+	sessions []*time.Time // Revocation time of each of the user's sessions
+}
+
+type resetUser struct {
+	id       int64
+	username string
+	email    string
+	password string
+}
+
+type resetToken struct {
+	id        int64
+	createdAt time.Time
+	userID    int64
+	tokenHash string
+	expiresAt time.Time
+	usedAt    *time.Time
+}
+
+var tokenColumns = []string{"id", "created_at", "user_id", "token_hash", "expires_at", "used_at"}
+
+func (t resetToken) row() []driver.Value {
+	var usedAt driver.Value
+	if t.usedAt != nil {
+		usedAt = *t.usedAt
+	}
+	return []driver.Value{t.id, t.createdAt, t.userID, t.tokenHash, t.expiresAt, usedAt}
+}
+
+// open returns a gorm database backed by d
+func (d *resetDB) open(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(d)}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func (d *resetDB) query(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, `SELECT * FROM "users" WHERE (email = $1 AND is_active = $2)`):
+		if args[0].Value != d.user.email {
+			return []string{"id"}, nil, nil
+		}
+		fallthrough
+	case strings.HasPrefix(query, `SELECT * FROM "users" WHERE "users"."id" = $1`):
+		return []string{"id", "username", "email", "password", "is_active"},
+			[][]driver.Value{{d.user.id, d.user.username, d.user.email, d.user.password, true}}, nil
+
+	case strings.HasPrefix(query, `SELECT count(*) FROM "password_reset_tokens" WHERE user_id = $1 AND used_at IS NULL AND expires_at > $2 AND created_at > $3`):
+		var count int64
+		for _, token := range d.tokens {
+			if token.userID == args[0].Value && token.usedAt == nil &&
+				token.expiresAt.After(args[1].Value.(time.Time)) && token.createdAt.After(args[2].Value.(time.Time)) {
+				count++
+			}
+		}
+		return []string{"count"}, [][]driver.Value{{count}}, nil
+
+	case strings.HasPrefix(query, `INSERT INTO "password_reset_tokens" ("created_at","user_id","token_hash","expires_at","used_at")`):
+		token := resetToken{
+			id:        int64(len(d.tokens) + 1),
+			createdAt: args[0].Value.(time.Time),
+			userID:    args[1].Value.(int64),
+			tokenHash: args[2].Value.(string),
+			expiresAt: args[3].Value.(time.Time),
+		}
+		d.tokens = append(d.tokens, token)
+		return []string{"id"}, [][]driver.Value{{token.id}}, nil
+
+	case strings.HasPrefix(query, `SELECT * FROM "password_reset_tokens" WHERE token_hash = $1`):
+		for _, token := range d.tokens {
+			if token.tokenHash == args[0].Value {
+				return tokenColumns, [][]driver.Value{token.row()}, nil
+			}
+		}
+		return tokenColumns, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("unexpected query %s", query)
+}
+
+func (d *resetDB) exec(query string, args []driver.NamedValue) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, `UPDATE "password_reset_tokens" SET "used_at"=$1 WHERE (used_at IS NULL AND expires_at > $2) AND "id" = $3`):
+		usedAt := args[0].Value.(time.Time)
+		for i := range d.tokens {
+			token := &d.tokens[i]
+			if token.id == args[2].Value && token.usedAt == nil && token.expiresAt.After(args[1].Value.(time.Time)) {
+				token.usedAt = &usedAt
+				return 1, nil
+			}
+		}
+		return 0, nil
+
+	case strings.HasPrefix(query, `UPDATE "password_reset_tokens" SET "used_at"=$1 WHERE user_id = $2 AND used_at IS NULL`):
+		usedAt := args[0].Value.(time.Time)
+		var affected int64
+		for i := range d.tokens {
+			if token := &d.tokens[i]; token.userID == args[1].Value && token.usedAt == nil {
+				token.usedAt = &usedAt
+				affected++
+			}
+		}
+		return affected, nil
+
+	case strings.HasPrefix(query, `UPDATE "users" SET "password"=$1,"updated_at"=$2 WHERE id = $3`):
+		if args[2].Value != d.user.id {
+			return 0, nil
+		}
+		d.user.password = args[0].Value.(string)
+		return 1, nil
+
+	case strings.HasPrefix(query, `UPDATE "sessions" SET "revoked_at"=$1,"updated_at"=$2 WHERE user_id = $3 AND revoked_at IS NULL`):
+		revokedAt := args[0].Value.(time.Time)
+		var affected int64
+		for i, revoked := range d.sessions {
+			if args[2].Value == d.user.id && revoked == nil {
+				d.sessions[i] = &revokedAt
+				affected++
+			}
+		}
+		return affected, nil
+	}
+
+	return 0, fmt.Errorf("unexpected statement %s", query)
+}
+
+// The database/sql plumbing: every connection and transaction goes straight
+// to the tables above
+
+func (d *resetDB) Connect(context.Context) (driver.Conn, error) { return resetConn{d}, nil }
+func (d *resetDB) Driver() driver.Driver                        { return nil }
+
+type resetConn struct{ db *resetDB }
+
+func (c resetConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c resetConn) Close() error                        { return nil }
+func (c resetConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c resetConn) Commit() error                       { return nil }
+func (c resetConn) Rollback() error                     { return nil }
+
+func (c resetConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.db.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &resetRows{columns: columns, rows: rows}, nil
+}
+
+func (c resetConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	affected, err := c.db.exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+type resetRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *resetRows) Columns() []string { return r.columns }
+func (r *resetRows) Close() error      { return nil }
+
+func (r *resetRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newResetHandler returns a handler whose database holds one user with two
+// active sessions, and the mailer it sends through
+func newResetHandler(t *testing.T) (*Handler, *resetDB, *mail.Memory) {
+	t.Helper()
+	db := &resetDB{
+		user:     resetUser{id: 1, username: "alice", email: "alice@example.com", password: "old-hash"},
+		sessions: []*time.Time{nil, nil},
+	}
+	mailer := mail.NewMemory()
+	h := New(db.open(t), Config{
+		Mailer:                      mailer,
+		AppURL:                      "https://app.example.com",
+		PasswordResetTTL:            time.Hour,
+		PasswordResetResendInterval: time.Minute,
+		IPLockout: lockout.NewLimiter(lockout.NewMemory(), lockout.Policy{
+			FreeAttempts: 3,
+			BaseDelay:    time.Minute,
+			MaxDelay:     time.Hour,
+			Window:       time.Hour,
+		}),
+	})
+	return h, db, mailer
+}
+
+func postJSON(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// waitForMessages waits for the mailer to have sent n messages, since
+// reset emails are sent in the background
+func waitForMessages(t *testing.T, mailer *mail.Memory, n int) []mail.Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		messages := mailer.Messages()
+		if len(messages) >= n || time.Now().After(deadline) {
+			if len(messages) != n {
+				t.Fatalf("sent %d messages, want %d", len(messages), n)
+			}
+			return messages
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// resetLinkToken returns the token in the reset link of an email body
+func resetLinkToken(t *testing.T, body string) string {
+	t.Helper()
+	const prefix = "https://app.example.com/reset-password?token="
+	start := strings.Index(body, prefix)
+	if start < 0 {
+		t.Fatalf("no reset link in %q", body)
+	}
+	link, _, _ := strings.Cut(body[start:], "\n")
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Query().Get("token")
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	h, db, mailer := newResetHandler(t)
+
+	if w := postJSON(h.ForgotPassword, `{"email": "alice@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("forgot password: status %d: %s", w.Code, w.Body)
+	}
+	messages := waitForMessages(t, mailer, 1)
+	if messages[0].To != "alice@example.com" {
+		t.Errorf("reset email sent to %q", messages[0].To)
+	}
+	token := resetLinkToken(t, messages[0].Body)
+
+	body := fmt.Sprintf(`{"token": %q, "password": "new-password"}`, token)
+	if w := postJSON(h.ResetPassword, body); w.Code != http.StatusOK {
+		t.Fatalf("reset password: status %d: %s", w.Code, w.Body)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(db.user.password), []byte("new-password")); err != nil {
+		t.Errorf("password wasn't changed: %v", err)
+	}
+	for i, revoked := range db.sessions {
+		if revoked == nil {
+			t.Errorf("session %d wasn't revoked", i)
+		}
+	}
+
+	// The link only works once
+	if w := postJSON(h.ResetPassword, fmt.Sprintf(`{"token": %q, "password": "another-password"}`, token)); w.Code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(db.user.password), []byte("new-password")); err != nil {
+		t.Error("reusing the token changed the password")
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	h, db, mailer := newResetHandler(t)
+
+	w := postJSON(h.ForgotPassword, `{"email": "nobody@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want the same response as for an account", w.Code)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if len(mailer.Messages()) != 0 || len(db.tokens) != 0 {
+		t.Error("a reset was started for an unknown email")
+	}
+}
+
+func TestForgotPasswordSkipsRecentLink(t *testing.T) {
+	h, db, mailer := newResetHandler(t)
+
+	for i := 0; i < 2; i++ {
+		if w := postJSON(h.ForgotPassword, `{"email": "alice@example.com"}`); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	waitForMessages(t, mailer, 1)
+	if len(db.tokens) != 1 {
+		t.Errorf("created %d tokens, want 1", len(db.tokens))
+	}
+
+	// Once the interval passes a new link is sent
+	db.tokens[0].createdAt = db.tokens[0].createdAt.Add(-2 * time.Minute)
+	if w := postJSON(h.ForgotPassword, `{"email": "alice@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	waitForMessages(t, mailer, 2)
+}
+
+func TestForgotPasswordThrottlesIP(t *testing.T) {
+	h, _, _ := newResetHandler(t)
+
+	for i := 0; i < 3; i++ {
+		if w := postJSON(h.ForgotPassword, fmt.Sprintf(`{"email": "user%d@example.com"}`, i)); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	// The fourth request is counted; the fifth must wait
+	postJSON(h.ForgotPassword, `{"email": "user3@example.com"}`)
+	w := postJSON(h.ForgotPassword, `{"email": "user4@example.com"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("throttled response has no Retry-After")
+	}
+}
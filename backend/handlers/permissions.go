@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// Permission allows a group of staff actions
+type Permission string
+
+const (
+	PermQuestsWrite       Permission = "quests:write"       // Create quests and edit unpublished drafts
+	PermQuestsPublish     Permission = "quests:publish"     // Publish, unpublish, edit live quests and delete quests
+	PermSubmissionsReview Permission = "submissions:review" // List, approve and reject submissions
+	PermUsersManage       Permission = "users:manage"       // Assign roles, verify emails and unlock accounts
+	PermAuditView         Permission = "audit:view"         // Read and export the audit log
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:        {},
+	models.RoleReviewer:    {PermSubmissionsReview},
+	models.RoleQuestAuthor: {PermQuestsWrite},
+	models.RoleAdmin:       {PermQuestsWrite, PermQuestsPublish, PermSubmissionsReview, PermUsersManage, PermAuditView},
+}
+
+// RoleInfo describes a role and the permissions it grants
+type RoleInfo struct {
+	Role        string       `json:"role"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// roleHasPermission reports whether role grants perm
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// roleWithin reports whether role grants nothing beyond what grantor's role
+// grants. The admin role is never within reach, so no one can hand it out
+// except by assigning it directly.
+func roleWithin(role, grantor string) bool {
+	if role == models.RoleAdmin {
+		return false
+	}
+	for _, perm := range rolePermissions[role] {
+		if !roleHasPermission(grantor, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPermission reports whether the authenticated user's role grants perm
+func hasPermission(r *http.Request, perm Permission) bool {
+	role, _ := r.Context().Value("user_role").(string)
+	return roleHasPermission(role, perm)
+}
+
+// Role Handlers
+
+// GetRoles lists the roles that can be assigned and their permissions
+func (h *Handler) GetRoles(w http.ResponseWriter, r *http.Request) {
+	roles := make([]RoleInfo, 0, len(rolePermissions))
+	for role, perms := range rolePermissions {
+		roles = append(roles, RoleInfo{Role: role, Permissions: perms})
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Role < roles[j].Role })
+
+	writeJSON(w, roles, http.StatusOK)
+}
+
+// SetUserRole assigns a role to a user. The change applies to the user's
+// next request, since roles are read from the database on every request.
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(uint)
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if _, ok := rolePermissions[req.Role]; !ok {
+		writeJSONError(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	// Don't let admins lock themselves out of role management
+	if userID == adminID {
+		writeJSONError(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditUserRoleChange, auditTargetUser, userID, before, user)
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, user, http.StatusOK)
+}
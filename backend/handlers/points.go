@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"koinonia-backend/models"
+)
+
+// PointsHistoryResponse is a page of a user's point ledger
+type PointsHistoryResponse struct {
+	TotalPoints  int                       `json:"total_points"`
+	Total        int64                     `json:"total"` // Number of ledger entries
+	Transactions []models.PointTransaction `json:"transactions"`
+}
+
+// PointDrift describes a user whose cached balance disagrees with the ledger
+type PointDrift struct {
+	UserID      uint   `json:"user_id"`
+	Username    string `json:"username"`
+	CachedTotal int    `json:"cached_total"`
+	LedgerTotal int    `json:"ledger_total"`
+}
+
+// Points Handlers
+
+// GetPointsHistory returns the current user's point ledger, newest first
+func (h *Handler) GetPointsHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	limit, offset := parsePagination(r, 50, 100)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	response := PointsHistoryResponse{TotalPoints: user.TotalPoints}
+	query := h.db.Model(&models.PointTransaction{}).Where("user_id = ?", userID).Session(&gorm.Session{})
+	if err := query.Count(&response.Total).Error; err != nil {
+		writeJSONError(w, "Failed to fetch points history", http.StatusInternalServerError)
+		return
+	}
+
+	if err := query.Preload("Submission.Quest").
+		Order("created_at DESC, id DESC").
+		Limit(limit).Offset(offset).
+		Find(&response.Transactions).Error; err != nil {
+		writeJSONError(w, "Failed to fetch points history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// recordPoints appends a ledger entry and applies it to the user's cached
+// balance. It must be called inside a transaction.
+func recordPoints(tx *gorm.DB, entry *models.PointTransaction) error {
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.User{}).Where("id = ?", entry.UserID).
+		UpdateColumn("total_points", gorm.Expr("total_points + ?", entry.Amount)).Error
+}
+
+// ReconcilePoints recomputes every user's balance from the ledger and returns
+// the users whose cached TotalPoints had drifted. With apply set, the cache is
+// overwritten with the ledger total. With backfill set, the drift is instead
+// recorded as an opening-balance ledger entry, which keeps balances earned
+// before the ledger existed.
+func (h *Handler) ReconcilePoints(apply, backfill bool) ([]PointDrift, error) {
+	drifts, err := pointDrifts(h.db, nil)
+	if err != nil || len(drifts) == 0 || (!apply && !backfill) {
+		return drifts, err
+	}
+
+	userIDs := make([]uint, len(drifts))
+	for i, drift := range drifts {
+		userIDs[i] = drift.UserID
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// recordPoints updates the user row in the same transaction as its
+		// ledger entry, so once the rows are locked the totals below can't
+		// miss an award that is being recorded concurrently
+		var locked []uint
+		if err := tx.Unscoped().Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", userIDs).Order("id").Pluck("id", &locked).Error; err != nil {
+			return err
+		}
+
+		var err error
+		drifts, err = pointDrifts(tx, locked)
+		if err != nil {
+			return err
+		}
+
+		for _, drift := range drifts {
+			if backfill {
+				entry := models.PointTransaction{
+					UserID: drift.UserID,
+					Amount: drift.CachedTotal - drift.LedgerTotal,
+					Reason: models.PointReasonOpeningBalance,
+					Note:   "Balance recorded during ledger reconciliation",
+				}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", drift.UserID).
+				UpdateColumn("total_points", drift.LedgerTotal).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return drifts, err
+}
+
+// pointDrifts lists users whose cached balance disagrees with the ledger,
+// limited to userIDs when it isn't nil
+func pointDrifts(tx *gorm.DB, userIDs []uint) ([]PointDrift, error) {
+	var drifts []PointDrift
+	if userIDs != nil && len(userIDs) == 0 {
+		return drifts, nil
+	}
+
+	query := tx.Table("users u").
+		Select(`u.id AS user_id, u.username, u.total_points AS cached_total,
+			COALESCE(SUM(pt.amount), 0) AS ledger_total`).
+		Joins("LEFT JOIN point_transactions pt ON pt.user_id = u.id").
+		Group("u.id, u.username, u.total_points").
+		Having("u.total_points <> COALESCE(SUM(pt.amount), 0)").
+		Order("u.id")
+	if userIDs != nil {
+		query = query.Where("u.id IN ?", userIDs)
+	}
+	err := query.Scan(&drifts).Error
+	return drifts, err
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+	"koinonia-backend/storage"
+)
+
+// reauthWindow is how recently a single sign-on login must have happened to
+// stand in for the password when deleting an account
+const reauthWindow = 10 * time.Minute
+
+// ProfileExport is everything stored about a user, returned for personal
+// data requests
+type ProfileExport struct {
+	ExportedAt        time.Time                 `json:"exported_at"`
+	User              models.User               `json:"user"`
+	Submissions       []models.Submission       `json:"submissions"`
+	PointTransactions []models.PointTransaction `json:"point_transactions"`
+	Uploads           []models.Upload           `json:"uploads"`
+	Sessions          []models.Session          `json:"sessions"`
+	Identities        []models.UserIdentity     `json:"identities"`            // Linked single sign-on accounts
+	MediaFiles        map[uint]string           `json:"media_files,omitempty"` // Upload ID to file path in the ZIP archive
+}
+
+// Privacy Handlers
+
+// ExportProfile downloads everything stored about the current user. By
+// default it is a ZIP archive of data.json and the user's uploaded media;
+// format=json returns just the JSON.
+func (h *Handler) ExportProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		writeJSONError(w, "format must be zip or json", http.StatusBadRequest)
+		return
+	}
+
+	export, err := h.loadProfileExport(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to export profile", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("koinonia-export-%d-%s", userID, export.ExportedAt.Format("20060102"))
+	if format == "json" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		writeJSON(w, export, http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	if err := h.writeProfileArchive(r.Context(), w, export); err != nil {
+		// Headers are already sent, so all we can do is cut the archive short
+		log.Printf("Failed to export profile of user %d: %v", userID, err)
+	}
+}
+
+// DeleteProfile deletes the current user's account. It needs the password,
+// and a two-factor code when enabled. Users with a linked single sign-on
+// identity may instead have logged in with it within reauthWindow, or give
+// the two-factor code alone. Personal details are anonymized at
+// once and the account is soft deleted, but approved submissions and points
+// are kept under the anonymized name so quest stats and leaderboard history
+// stay intact. PurgeDeletedUsers removes the rest after a grace period.
+func (h *Handler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		// Single sign-on accounts have a random password no one knows, so a
+		// fresh single sign-on login or a two-factor code stands in for it
+		ok, err := h.reauthenticatedWithoutPassword(r, &user)
+		if err != nil {
+			writeJSONError(w, "Failed to check authentication", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			writeJSONErrorCode(w, "Enter your password, or sign in again with single sign-on, to delete your account", "reauthentication_required", http.StatusUnauthorized)
+			return
+		}
+	}
+	if user.TwoFactorEnabledAt != nil && !h.checkTwoFactorAttempt(w, r, &user, http.StatusUnauthorized, func() bool {
+		return h.checkSecondFactor(&user, req.Code, req.RecoveryCode)
+	}) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockUser(tx, userID); err != nil {
+			return err
+		}
+		if err := anonymizeUser(tx, userID); err != nil {
+			return err
+		}
+		if err := recordAudit(tx, r, auditUserDelete, auditTargetUser, userID, nil, nil); err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, userID).Error
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Account deleted"}, http.StatusOK)
+}
+
+// reauthenticatedWithoutPassword reports whether a user with a linked
+// single sign-on identity may skip the password: their session must come
+// from a single sign-on login within reauthWindow, or they must have
+// two-factor authentication, whose code DeleteProfile then requires.
+func (h *Handler) reauthenticatedWithoutPassword(r *http.Request, user *models.User) (bool, error) {
+	var identities int64
+	if err := h.db.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+		return false, err
+	}
+	if identities == 0 {
+		return false, nil
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return true, nil
+	}
+
+	sessionID, _ := r.Context().Value("session_id").(uint)
+	var fresh int64
+	err := h.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND login_method = ? AND created_at > ?",
+			sessionID, user.ID, models.LoginMethodOIDC, time.Now().Add(-reauthWindow)).
+		Count(&fresh).Error
+	return fresh > 0, err
+}
+
+// PurgeDeletedUsers permanently removes the personal data of accounts
+// deleted more than grace ago: uploaded media, submission content, sessions
+// and the personal details in audit snapshots. The anonymized user row is
+// kept, since approved submissions, reviews and audit events still
+// reference it. It returns how many accounts were purged.
+func (h *Handler) PurgeDeletedUsers(ctx context.Context, grace time.Duration) (int, error) {
+	var users []models.User
+	err := h.db.WithContext(ctx).Unscoped().
+		Where("deleted_at < ? AND purged_at IS NULL", time.Now().Add(-grace)).
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err := h.purgeUser(ctx, user.ID); err != nil {
+			return purged, fmt.Errorf("purging user %d: %w", user.ID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// loadProfileExport gathers a user's data for export
+func (h *Handler) loadProfileExport(userID uint) (*ProfileExport, error) {
+	export := ProfileExport{ExportedAt: time.Now().UTC()}
+	if err := h.db.First(&export.User, userID).Error; err != nil {
+		return nil, err
+	}
+
+	queries := []struct {
+		query *gorm.DB
+		dest  interface{}
+	}{
+		{h.db.Preload("Quest"), &export.Submissions},
+		{h.db, &export.PointTransactions},
+		{h.db, &export.Uploads},
+		{h.db, &export.Sessions},
+		{h.db, &export.Identities},
+	}
+	for _, q := range queries {
+		if err := q.query.Where("user_id = ?", userID).Order("created_at, id").Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &export, nil
+}
+
+// writeProfileArchive writes a ZIP archive of the user's uploaded media and
+// data.json. The JSON goes last so it can list which media files made it
+// into the archive.
+func (h *Handler) writeProfileArchive(ctx context.Context, w http.ResponseWriter, export *ProfileExport) error {
+	archive := zip.NewWriter(w)
+
+	if h.cfg.Storage != nil {
+		export.MediaFiles = map[uint]string{}
+		for _, upload := range export.Uploads {
+			data, err := h.cfg.Storage.Get(ctx, upload.Key)
+			if err != nil {
+				log.Printf("Failed to export upload %d: %v", upload.ID, err)
+				continue
+			}
+			name := "media/" + path.Base(upload.Key)
+			file, err := archive.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(data); err != nil {
+				return err
+			}
+			export.MediaFiles[upload.ID] = name
+		}
+	}
+
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// anonymizeUser replaces a user's personal details with placeholders, signs
+// them out everywhere and removes their login methods and pending work
+func anonymizeUser(tx *gorm.DB, userID uint) error {
+	placeholder := fmt.Sprintf("deleted-user-%d", userID)
+	err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"username":              placeholder,
+		"email":                 placeholder + "@deleted.invalid",
+		"password":              "", // Matches no bcrypt comparison
+		"first_name":            "",
+		"last_name":             "",
+		"avatar":                "",
+		"bio":                   "",
+		"role":                  models.RoleUser,
+		"two_factor_secret":     "",
+		"two_factor_enabled_at": nil,
+		"verification_sent_at":  nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if err := revokeUserSessions(tx, userID); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&models.RecoveryCode{}, &models.PasswordResetToken{}, &models.UserIdentity{}} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// Drop pending submissions so they don't sit in the review queue
+	return tx.Where("user_id = ? AND status = ?", userID, models.SubmissionStatusPending).
+		Delete(&models.Submission{}).Error
+}
+
+// purgeUser removes the remaining personal data of a deleted user. Media
+// is deleted from storage first; if that fails the user is left for the
+// next run.
+func (h *Handler) purgeUser(ctx context.Context, userID uint) error {
+	var uploads []models.Upload
+	if err := h.db.WithContext(ctx).Where("user_id = ?", userID).Find(&uploads).Error; err != nil {
+		return err
+	}
+	if h.cfg.Storage != nil {
+		for _, upload := range uploads {
+			keys := []string{upload.Key}
+			if upload.ThumbnailURL != "" {
+				keys = append(keys, variantKey(upload.Key, imageVariantThumb))
+			}
+			if upload.PreviewURL != "" {
+				keys = append(keys, variantKey(upload.Key, imageVariantPreview))
+			}
+			for _, key := range keys {
+				if err := h.cfg.Storage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+					return err
+				}
+			}
+		}
+	}
+
+	return h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Upload{}).Error; err != nil {
+			return err
+		}
+
+		// Only approved submissions count toward stats; keep those without
+		// their content
+		if err := tx.Unscoped().Where("user_id = ? AND status <> ?", userID, models.SubmissionStatusApproved).
+			Delete(&models.Submission{}).Error; err != nil {
+			return err
+		}
+		err := tx.Unscoped().Model(&models.Submission{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"content":       "",
+			"media_url":     "",
+			"thumbnail_url": "",
+			"preview_url":   "",
+			"grading_diff":  "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&models.Session{}, &models.LockoutEvent{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("attempt_key IN ?", []string{accountLockoutKey(userID), twoFactorLockoutKey(userID)}).
+			Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		// Snapshots of the user taken by admin actions hold their old details
+		err = tx.Model(&models.AuditEvent{}).
+			Where("target_type = ? AND target_id = ?", auditTargetUser, userID).
+			Updates(map[string]interface{}{"before": "", "after": ""}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Update("purged_at", time.Now()).Error
+	})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// submissionSummary totals a user's submissions to one quest occurrence
+type submissionSummary struct {
+	QuestID         uint
+	OccurrenceStart *time.Time
+	Approved        int
+	Pending         int
+	Rejected        int
+	LatestID        uint
+	LatestStatus    models.SubmissionStatus
+	LatestNotes     string
+	LatestAt        time.Time
+}
+
+// add folds another occurrence's totals into s
+func (s *submissionSummary) add(other submissionSummary) {
+	s.Approved += other.Approved
+	s.Pending += other.Pending
+	s.Rejected += other.Rejected
+	if s.LatestID == 0 || other.LatestAt.After(s.LatestAt) {
+		s.LatestID, s.LatestStatus, s.LatestNotes, s.LatestAt =
+			other.LatestID, other.LatestStatus, other.LatestNotes, other.LatestAt
+	}
+}
+
+// applyQuestProgress fills in the user's progress on each quest from a
+// single query. Recurring quests only count submissions to their current
+// occurrence, so annotateQuest must run first.
+func (h *Handler) applyQuestProgress(tx *gorm.DB, userID uint, quests []models.Quest) error {
+	if len(quests) == 0 {
+		return nil
+	}
+
+	// The latest submission comes from ordered aggregates rather than a
+	// second query per quest
+	var summaries []submissionSummary
+	err := tx.Model(&models.Submission{}).
+		Select(`quest_id, occurrence_start,
+			COUNT(*) FILTER (WHERE status = ?) AS approved,
+			COUNT(*) FILTER (WHERE status = ?) AS pending,
+			COUNT(*) FILTER (WHERE status = ?) AS rejected,
+			(ARRAY_AGG(id ORDER BY created_at DESC, id DESC))[1] AS latest_id,
+			(ARRAY_AGG(status ORDER BY created_at DESC, id DESC))[1] AS latest_status,
+			(ARRAY_AGG(admin_notes ORDER BY created_at DESC, id DESC))[1] AS latest_notes,
+			MAX(created_at) AS latest_at`,
+			models.SubmissionStatusApproved, models.SubmissionStatusPending, models.SubmissionStatusRejected).
+		Where("user_id = ? AND quest_id IN ? AND status <> ?", userID, questIDs(quests), models.SubmissionStatusWithdrawn).
+		Group("quest_id, occurrence_start").
+		Scan(&summaries).Error
+	if err != nil {
+		return err
+	}
+
+	byQuest := map[uint][]submissionSummary{}
+	for _, summary := range summaries {
+		byQuest[summary.QuestID] = append(byQuest[summary.QuestID], summary)
+	}
+
+	for i := range quests {
+		quest := &quests[i]
+		recurring := h.questSchedule(quest) != nil
+
+		var total submissionSummary
+		for _, summary := range byQuest[quest.ID] {
+			if recurring && !sameOccurrence(quest.Occurrence, summary.OccurrenceStart) {
+				continue
+			}
+			total.add(summary)
+		}
+		quest.Progress = questProgress(quest, total)
+	}
+	return nil
+}
+
+// questProgress describes where a user stands on a quest given their
+// submission totals. Withdrawn submissions aren't counted.
+func questProgress(quest *models.Quest, total submissionSummary) *models.QuestProgress {
+	progress := &models.QuestProgress{Status: models.QuestStatusNotStarted}
+	if total.LatestID != 0 {
+		progress.SubmissionID = &total.LatestID
+		progress.SubmittedAt = &total.LatestAt
+		progress.AdminNotes = total.LatestNotes
+	}
+
+	switch {
+	case total.Approved > 0:
+		progress.Status = models.QuestStatusApproved
+	case total.Pending > 0:
+		progress.Status = models.QuestStatusPending
+	case total.Rejected > 0:
+		progress.Status = models.QuestStatusRejected
+	}
+
+	// Rejected submissions don't count toward the limit
+	if quest.MaxSubmissions > 0 {
+		remaining := quest.MaxSubmissions - total.Approved - total.Pending
+		if remaining < 0 {
+			remaining = 0
+		}
+		progress.RemainingAttempts = &remaining
+	}
+
+	// The first rejection is free; each one after it uses up a retry
+	if quest.MaxRetries > 0 {
+		used := total.Rejected - 1
+		if used < 0 {
+			used = 0
+		}
+		remaining := quest.MaxRetries - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		progress.RemainingRetries = &remaining
+	}
+	return progress
+}
+
+// sameOccurrence reports whether a submission's occurrence start is the
+// current occurrence. Stored times lose sub-microsecond precision.
+func sameOccurrence(current *models.QuestOccurrence, start *time.Time) bool {
+	if current == nil || start == nil {
+		return false
+	}
+	diff := current.StartsAt.Sub(*start)
+	return diff > -time.Microsecond && diff < time.Microsecond
+}
+
+// parseQuestStatuses parses a comma-separated status filter
+func parseQuestStatuses(value string) (map[models.QuestStatus]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	statuses := map[models.QuestStatus]bool{}
+	for _, part := range strings.Split(value, ",") {
+		status := models.QuestStatus(strings.TrimSpace(part))
+		switch status {
+		case models.QuestStatusNotStarted, models.QuestStatusPending,
+			models.QuestStatusApproved, models.QuestStatusRejected:
+			statuses[status] = true
+		default:
+			return nil, fmt.Errorf("Invalid status %q", part)
+		}
+	}
+	return statuses, nil
+}
@@ -2,22 +2,66 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
-	"github.com/go-chi/chi/v5"
 	"koinonia-backend/models"
+	"koinonia-backend/recurrence"
+)
+
+// Reasons a quest cannot currently be viewed or submitted
+const (
+	QuestUnavailableNotStarted   = "not_started"
+	QuestUnavailableExpired      = "expired"
+	QuestUnavailableLimitReached = "limit_reached"
+	QuestUnavailableLocked       = "locked"
+	QuestUnavailableRetryLimit   = "retry_limit_reached"
+)
+
+var (
+	// errSubmissionLimitReached is returned when a user has used up a quest's submissions
+	errSubmissionLimitReached = errors.New("submission limit reached")
+	// errRetryLimitReached is returned when a user has used up a quest's retries
+	errRetryLimitReached = errors.New("retry limit reached")
+	// errAlreadyResubmitted is returned when a rejected submission already has a retry
+	errAlreadyResubmitted = errors.New("submission already resubmitted")
 )
 
 // Quest Handlers
 
-// GetQuests returns all active quests
+// GetQuests returns all active quests with their current occurrence,
+// whether the user has unlocked them and the user's progress.
+// featured=true limits the list to today's featured quests and status to
+// quests where the user's progress has one of the given statuses. Quest
+// authors can include unpublished drafts with include_drafts=true.
 func (h *Handler) GetQuests(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
 	// Query parameters for filtering
 	questType := r.URL.Query().Get("type")
 	difficulty := r.URL.Query().Get("difficulty")
+	statuses, err := parseQuestStatuses(r.URL.Query().Get("status"))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	canWrite := hasPermission(r, PermQuestsWrite)
+	now := time.Now()
 
-	query := h.db.Where("is_active = ?", true)
+	query := h.db.Model(&models.Quest{})
+	if !canWrite || !queryBool(r, "include_drafts") {
+		query = query.Where("is_active = ?", true)
+	}
+
+	// Only quest staff see quests outside their availability window
+	if !canWrite {
+		query = query.Where("(start_date IS NULL OR start_date <= ?) AND (end_date IS NULL OR end_date > ?)", now, now)
+	}
 
 	// Apply filters if provided
 	if questType != "" {
@@ -27,6 +71,16 @@ func (h *Handler) GetQuests(w http.ResponseWriter, r *http.Request) {
 		query = query.Where("difficulty = ?", difficulty)
 	}
 
+	featured, err := h.featuredQuestIDs(now)
+	if err != nil {
+		writeJSONError(w, "Failed to fetch quests", http.StatusInternalServerError)
+		return
+	}
+	if queryBool(r, "featured") {
+		query = query.Where("id IN (?)", h.db.Model(&models.FeaturedQuest{}).
+			Select("quest_id").Where("date = ?", h.featuredDate(now)))
+	}
+
 	// Get quests ordered by creation date
 	var quests []models.Quest
 	if err := query.Order("created_at DESC").Find(&quests).Error; err != nil {
@@ -34,27 +88,86 @@ func (h *Handler) GetQuests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, quests, http.StatusOK)
+	// Recurring quests between occurrences are hidden like expired ones
+	available := quests[:0]
+	for i := range quests {
+		if !canWrite {
+			if reason, _ := h.questAvailability(&quests[i], now); reason != "" {
+				continue
+			}
+		}
+		h.annotateQuest(&quests[i], now, featured)
+		available = append(available, quests[i])
+	}
+	if err := h.applyQuestLocks(h.db, userID, available); err != nil {
+		writeJSONError(w, "Failed to fetch quests", http.StatusInternalServerError)
+		return
+	}
+	if err := h.applyQuestProgress(h.db, userID, available); err != nil {
+		writeJSONError(w, "Failed to fetch quests", http.StatusInternalServerError)
+		return
+	}
+
+	if statuses != nil {
+		matching := available[:0]
+		for _, quest := range available {
+			if statuses[quest.Progress.Status] {
+				matching = append(matching, quest)
+			}
+		}
+		available = matching
+	}
+
+	writeJSON(w, available, http.StatusOK)
 }
 
 // GetQuest returns a specific quest by ID
 func (h *Handler) GetQuest(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
 	questID, err := parseID(r, "id")
 	if err != nil {
 		writeJSONError(w, "Invalid quest ID", http.StatusBadRequest)
 		return
 	}
 
+	// Drafts are only visible to quest staff
+	canWrite := hasPermission(r, PermQuestsWrite)
 	var quest models.Quest
-	if err := h.db.Where("id = ? AND is_active = ?", questID, true).First(&quest).Error; err != nil {
+	if err := h.db.First(&quest, questID).Error; err != nil || (!quest.IsActive && !canWrite) {
 		writeJSONError(w, "Quest not found", http.StatusNotFound)
 		return
 	}
 
-	writeJSON(w, quest, http.StatusOK)
+	now := time.Now()
+	if !canWrite {
+		if reason, _ := h.questAvailability(&quest, now); reason != "" {
+			writeQuestUnavailable(w, reason)
+			return
+		}
+	}
+
+	featured, err := h.featuredQuestIDs(now)
+	if err != nil {
+		writeJSONError(w, "Failed to fetch quest", http.StatusInternalServerError)
+		return
+	}
+	h.annotateQuest(&quest, now, featured)
+
+	quests := []models.Quest{quest}
+	if err := h.applyQuestLocks(h.db, userID, quests); err != nil {
+		writeJSONError(w, "Failed to fetch quest", http.StatusInternalServerError)
+		return
+	}
+	if err := h.applyQuestProgress(h.db, userID, quests); err != nil {
+		writeJSONError(w, "Failed to fetch quest", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, quests[0], http.StatusOK)
 }
 
-// SubmitQuest allows a user to submit a quest completion
+// SubmitQuest allows a user to submit a quest completion. Setting
+// previous_submission_id to a rejected submission makes this a retry of it.
 func (h *Handler) SubmitQuest(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(uint)
 	questID, err := parseID(r, "id")
@@ -65,8 +178,10 @@ func (h *Handler) SubmitQuest(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Content   string `json:"content"`    // Text response/answer
-		MediaURL  string `json:"media_url"`  // URL to uploaded media
-		MediaType string `json:"media_type"` // "image", "video", "audio"
+		MediaURL  string `json:"media_url"`  // URL returned by POST /api/uploads
+		MediaType string `json:"media_type"` // Ignored; derived from the upload
+
+		PreviousSubmissionID *uint `json:"previous_submission_id"` // Rejected attempt this retries
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -81,27 +196,149 @@ func (h *Handler) SubmitQuest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user has already submitted this quest (if applicable)
-	var existingSubmission models.Submission
-	if err := h.db.Where("user_id = ? AND quest_id = ?", userID, questID).First(&existingSubmission).Error; err == nil {
-		// User already submitted - check if quest allows multiple submissions
-		if quest.MaxSubmissions == 1 {
-			writeJSONError(w, "You have already submitted this quest", http.StatusConflict)
-			return
-		}
+	// Verify quest is within its availability window, and for recurring
+	// quests that an occurrence is in progress
+	reason, occurrence := h.questAvailability(&quest, time.Now())
+	if reason != "" {
+		writeQuestUnavailable(w, reason)
+		return
+	}
+
+	// Verify the user has completed the quest's prerequisites
+	missing, err := h.missingPrerequisites(userID, &quest)
+	if err != nil {
+		writeJSONError(w, "Failed to check prerequisites", http.StatusInternalServerError)
+		return
+	}
+	if len(missing) > 0 {
+		writeJSONErrorCode(w, "Complete these quests first: "+strings.Join(missing, ", "),
+			QuestUnavailableLocked, http.StatusForbidden)
+		return
 	}
 
 	// Create submission
 	submission := models.Submission{
-		UserID:    userID,
-		QuestID:   questID,
-		Content:   req.Content,
-		MediaURL:  req.MediaURL,
-		MediaType: req.MediaType,
-		Status:    models.SubmissionStatusPending,
+		UserID:  userID,
+		QuestID: questID,
+		Content: req.Content,
+		Status:  models.SubmissionStatusPending,
+		Attempt: 1,
+	}
+	if occurrence != nil {
+		submission.OccurrenceStart = &occurrence.Start
+	}
+
+	// A retry must follow one of the user's own rejected attempts
+	if req.PreviousSubmissionID != nil {
+		var previous models.Submission
+		if err := h.db.Where("id = ? AND user_id = ?", *req.PreviousSubmissionID, userID).First(&previous).Error; err != nil {
+			writeJSONError(w, "Previous submission not found", http.StatusNotFound)
+			return
+		}
+		if previous.QuestID != questID {
+			writeJSONError(w, "Previous submission is for a different quest", http.StatusBadRequest)
+			return
+		}
+		if previous.Status != models.SubmissionStatusRejected {
+			writeJSONError(w, "Only rejected submissions can be resubmitted", http.StatusConflict)
+			return
+		}
+		submission.PreviousSubmissionID = &previous.ID
+		submission.Attempt = previous.Attempt + 1
+		if previous.Attempt < 1 {
+			submission.Attempt = 2 // Submissions from before attempts were numbered
+		}
+	}
+
+	// Media must be one of the caller's own uploads
+	if !h.attachMedia(w, userID, &submission, req.MediaURL) {
+		return
 	}
 
-	if err := h.db.Create(&submission).Error; err != nil {
+	// Trivia and scripture quests are graded as soon as they arrive
+	verdict, notes, err := h.gradeSubmission(&quest, &submission)
+	if errors.Is(err, errInvalidTriviaOption) {
+		writeJSONError(w, "Answer must be one of the quest's options", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to grade submission", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if quest.MaxSubmissions > 0 || quest.MaxRetries > 0 || submission.PreviousSubmissionID != nil {
+			// Lock the user's row so concurrent submits are counted one at a time
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+				return err
+			}
+		}
+
+		// Limits on recurring quests reset with each occurrence
+		counted := func(statuses ...models.SubmissionStatus) (int64, error) {
+			query := tx.Model(&models.Submission{}).
+				Where("user_id = ? AND quest_id = ? AND status IN ?", userID, questID, statuses)
+			if occurrence != nil {
+				query = query.Where("occurrence_start = ?", occurrence.Start)
+			}
+			var count int64
+			err := query.Count(&count).Error
+			return count, err
+		}
+
+		// Rejected and withdrawn submissions don't use up the limit
+		if quest.MaxSubmissions > 0 {
+			count, err := counted(models.SubmissionStatusPending, models.SubmissionStatusApproved)
+			if err != nil {
+				return err
+			}
+			if count >= int64(quest.MaxSubmissions) {
+				return errSubmissionLimitReached
+			}
+		}
+
+		// Each rejection after the first uses up a retry
+		if quest.MaxRetries > 0 {
+			rejected, err := counted(models.SubmissionStatusRejected)
+			if err != nil {
+				return err
+			}
+			if rejected > int64(quest.MaxRetries) {
+				return errRetryLimitReached
+			}
+		}
+
+		// A rejected attempt can only be retried once, unless the retry is withdrawn
+		if submission.PreviousSubmissionID != nil {
+			var retries int64
+			if err := tx.Model(&models.Submission{}).
+				Where("previous_submission_id = ? AND status <> ?", *submission.PreviousSubmissionID, models.SubmissionStatusWithdrawn).
+				Count(&retries).Error; err != nil {
+				return err
+			}
+			if retries > 0 {
+				return errAlreadyResubmitted
+			}
+		}
+
+		if err := tx.Create(&submission).Error; err != nil {
+			return err
+		}
+		return applyVerdict(tx, &submission, &quest, verdict, notes)
+	})
+	if errors.Is(err, errSubmissionLimitReached) {
+		writeQuestUnavailable(w, QuestUnavailableLimitReached)
+		return
+	}
+	if errors.Is(err, errRetryLimitReached) {
+		writeQuestUnavailable(w, QuestUnavailableRetryLimit)
+		return
+	}
+	if errors.Is(err, errAlreadyResubmitted) {
+		writeJSONError(w, "That submission has already been resubmitted", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		writeJSONError(w, "Failed to create submission", http.StatusInternalServerError)
 		return
 	}
@@ -112,14 +349,83 @@ func (h *Handler) SubmitQuest(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, submission, http.StatusCreated)
 }
 
-// Admin Quest Handlers
+// writeQuestUnavailable writes an error explaining why a quest is unavailable
+func writeQuestUnavailable(w http.ResponseWriter, reason string) {
+	switch reason {
+	case QuestUnavailableNotStarted:
+		writeJSONErrorCode(w, "Quest has not started yet", reason, http.StatusForbidden)
+	case QuestUnavailableExpired:
+		writeJSONErrorCode(w, "Quest has expired", reason, http.StatusForbidden)
+	case QuestUnavailableLimitReached:
+		writeJSONErrorCode(w, "You have reached the submission limit for this quest", reason, http.StatusConflict)
+	case QuestUnavailableLocked:
+		writeJSONErrorCode(w, "Complete this quest's prerequisites first", reason, http.StatusForbidden)
+	case QuestUnavailableRetryLimit:
+		writeJSONErrorCode(w, "You have no retries left for this quest", reason, http.StatusConflict)
+	}
+}
+
+// Quest Authoring Handlers
 
-// CreateQuest allows admins to create new quests
-func (h *Handler) CreateQuest(w http.ResponseWriter, r *http.Request) {
-	var req models.Quest
+var (
+	// errQuestNotFound is returned when an authoring action targets a missing quest
+	errQuestNotFound = errors.New("quest not found")
+	// errQuestPublished is returned when an author without publish permission
+	// edits a live quest
+	errQuestPublished = errors.New("quest is published")
+)
 
+// questRequest is the admin payload for creating or updating a quest. It
+// accepts the correct answer, which is hidden from quest responses.
+type questRequest struct {
+	models.Quest
+	CorrectAnswer string `json:"correct_answer"`
+}
+
+// decodeQuestRequest parses and validates an admin quest payload
+func decodeQuestRequest(r *http.Request) (models.Quest, error) {
+	var req questRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return models.Quest{}, errors.New("Invalid JSON")
+	}
+
+	quest := req.Quest
+	quest.CorrectAnswer = req.CorrectAnswer
+	quest.FeaturePool = false // Set with the feature and unfeature endpoints
+	quest.ChainID = nil       // Set with the chain endpoints
+	quest.ChainPosition = 0
+	quest.Locked = false
+
+	if quest.Recurrence != "" {
+		rule, err := recurrence.Parse(quest.Recurrence)
+		if err != nil {
+			return models.Quest{}, fmt.Errorf("Invalid recurrence: %v", err)
+		}
+		quest.Recurrence = rule.String()
+	}
+	if quest.OccurrenceMinutes < 0 {
+		return models.Quest{}, errors.New("Occurrence length cannot be negative")
+	}
+
+	options, err := parseTriviaOptions(quest.TriviaOptions)
+	if err != nil {
+		return models.Quest{}, errors.New("Trivia options must be a JSON array of strings")
+	}
+	if len(options) > 0 && quest.CorrectAnswer != "" {
+		if _, err := gradeTrivia(&quest, quest.CorrectAnswer); err != nil {
+			return models.Quest{}, errors.New("Correct answer must be one of the trivia options")
+		}
+	}
+
+	return quest, nil
+}
+
+// CreateQuest creates a new quest. Quest authors without publish
+// permission always create unpublished drafts.
+func (h *Handler) CreateQuest(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeQuestRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -129,8 +435,30 @@ func (h *Handler) CreateQuest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create quest
-	if err := h.db.Create(&req).Error; err != nil {
+	// Create quest. is_active has a database default, so drafts are
+	// unpublished explicitly after insert.
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&req).Error; err != nil {
+			return err
+		}
+		if !hasPermission(r, PermQuestsPublish) {
+			req.IsActive = false
+			if err := tx.Model(&req).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		if err := setQuestPrerequisites(tx, req.ID, req.PrerequisiteIDs); err != nil {
+			return err
+		}
+		if err := loadPrerequisiteIDs(tx, &req); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditQuestCreate, auditTargetQuest, req.ID, nil, req)
+	})
+	if writePrerequisiteError(w, err) {
+		return
+	}
+	if err != nil {
 		writeJSONError(w, "Failed to create quest", http.StatusInternalServerError)
 		return
 	}
@@ -138,7 +466,8 @@ func (h *Handler) CreateQuest(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, req, http.StatusCreated)
 }
 
-// UpdateQuest allows admins to update existing quests
+// UpdateQuest updates an existing quest. Quest authors without publish
+// permission can only edit drafts and cannot publish them.
 func (h *Handler) UpdateQuest(w http.ResponseWriter, r *http.Request) {
 	questID, err := parseID(r, "id")
 	if err != nil {
@@ -146,21 +475,117 @@ func (h *Handler) UpdateQuest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req models.Quest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+	req, err := decodeQuestRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	canPublish := hasPermission(r, PermQuestsPublish)
 
-	// Update quest
-	if err := h.db.Model(&models.Quest{}).Where("id = ?", questID).Updates(&req).Error; err != nil {
+	var quest models.Quest
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuest(tx, questID)
+		if err != nil {
+			return err
+		}
+		if err := loadPrerequisiteIDs(tx, before); err != nil {
+			return err
+		}
+		if !canPublish {
+			if before.IsActive {
+				return errQuestPublished
+			}
+			req.IsActive = false // Zero values aren't updated, so the quest stays a draft
+		}
+
+		if err := tx.Model(&models.Quest{}).Where("id = ?", questID).Updates(&req).Error; err != nil {
+			return err
+		}
+		if req.PrerequisiteIDs != nil {
+			if err := setQuestPrerequisites(tx, questID, req.PrerequisiteIDs); err != nil {
+				return err
+			}
+		}
+		if err := tx.First(&quest, questID).Error; err != nil {
+			return err
+		}
+		if err := loadPrerequisiteIDs(tx, &quest); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditQuestUpdate, auditTargetQuest, questID, before, quest)
+	})
+	if writePrerequisiteError(w, err) {
+		return
+	}
+	if errors.Is(err, errQuestNotFound) {
+		writeJSONError(w, "Quest not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errQuestPublished) {
+		writeJSONError(w, "Only publishers can edit published quests", http.StatusForbidden)
+		return
+	}
+	if err != nil {
 		writeJSONError(w, "Failed to update quest", http.StatusInternalServerError)
 		return
 	}
 
-	// Return updated quest
+	writeJSON(w, quest, http.StatusOK)
+}
+
+// PublishQuest makes a draft quest visible to users
+func (h *Handler) PublishQuest(w http.ResponseWriter, r *http.Request) {
+	h.setQuestFlag(w, r, "is_active", true, auditQuestPublish)
+}
+
+// UnpublishQuest hides a quest from users, returning it to draft
+func (h *Handler) UnpublishQuest(w http.ResponseWriter, r *http.Request) {
+	h.setQuestFlag(w, r, "is_active", false, auditQuestUnpublish)
+}
+
+// FeatureQuest adds a quest to the pool the daily featured quests are
+// picked from
+func (h *Handler) FeatureQuest(w http.ResponseWriter, r *http.Request) {
+	h.setQuestFlag(w, r, "feature_pool", true, auditQuestFeature)
+}
+
+// UnfeatureQuest removes a quest from the featured pool. It stays featured
+// if it was already picked for today.
+func (h *Handler) UnfeatureQuest(w http.ResponseWriter, r *http.Request) {
+	h.setQuestFlag(w, r, "feature_pool", false, auditQuestUnfeature)
+}
+
+// setQuestFlag sets a boolean quest column, such as whether it is active
+func (h *Handler) setQuestFlag(w http.ResponseWriter, r *http.Request, column string, value bool, action string) {
+	questID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid quest ID", http.StatusBadRequest)
+		return
+	}
+
 	var quest models.Quest
-	h.db.First(&quest, questID)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuest(tx, questID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Quest{}).Where("id = ?", questID).Update(column, value).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&quest, questID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, action, auditTargetQuest, questID, before, quest)
+	})
+	if errors.Is(err, errQuestNotFound) {
+		writeJSONError(w, "Quest not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to update quest", http.StatusInternalServerError)
+		return
+	}
+
 	writeJSON(w, quest, http.StatusOK)
 }
 
@@ -173,10 +598,38 @@ func (h *Handler) DeleteQuest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Soft delete the quest
-	if err := h.db.Delete(&models.Quest{}, questID).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuest(tx, questID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Quest{}, questID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditQuestDelete, auditTargetQuest, questID, before, nil)
+	})
+	if errors.Is(err, errQuestNotFound) {
+		writeJSONError(w, "Quest not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		writeJSONError(w, "Failed to delete quest", http.StatusInternalServerError)
 		return
 	}
 
 	writeJSON(w, MessageResponse{Message: "Quest deleted successfully"}, http.StatusOK)
 }
+
+// lockQuest loads a quest for update, so concurrent edits are applied one at
+// a time and audited with accurate snapshots
+func lockQuest(tx *gorm.DB, questID uint) (*models.Quest, error) {
+	var quest models.Quest
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&quest, questID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errQuestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quest, nil
+}
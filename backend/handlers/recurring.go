@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"koinonia-backend/models"
+	"koinonia-backend/recurrence"
+)
+
+// questSchedule returns the schedule of a recurring quest, or nil for a
+// one-off quest
+func (h *Handler) questSchedule(quest *models.Quest) *recurrence.Schedule {
+	if quest.Recurrence == "" {
+		return nil
+	}
+
+	rule, err := recurrence.Parse(quest.Recurrence)
+	if err != nil {
+		// Rules are validated when saved, so this only happens to rows
+		// edited by hand; treat the quest as a one-off
+		log.Printf("Ignoring invalid recurrence on quest %d: %v", quest.ID, err)
+		return nil
+	}
+
+	anchor := quest.CreatedAt
+	if quest.StartDate != nil {
+		anchor = *quest.StartDate
+	}
+	return &recurrence.Schedule{
+		Rule:     rule,
+		Anchor:   anchor,
+		Length:   time.Duration(quest.OccurrenceMinutes) * time.Minute,
+		Location: h.location(),
+	}
+}
+
+// questAvailability reports why a quest can't be submitted to at now, or ""
+// if it can. For recurring quests it also returns the occurrence in
+// progress, ending no later than the quest's end date.
+func (h *Handler) questAvailability(quest *models.Quest, now time.Time) (string, *recurrence.Occurrence) {
+	if quest.StartDate != nil && now.Before(*quest.StartDate) {
+		return QuestUnavailableNotStarted, nil
+	}
+	if quest.EndDate != nil && !now.Before(*quest.EndDate) {
+		return QuestUnavailableExpired, nil
+	}
+
+	schedule := h.questSchedule(quest)
+	if schedule == nil {
+		return "", nil
+	}
+
+	occurrence, ok := schedule.At(now)
+	if !ok {
+		// Between occurrences, or after the last one
+		next, ok := schedule.Next(now)
+		if ok && (quest.EndDate == nil || next.Start.Before(*quest.EndDate)) {
+			return QuestUnavailableNotStarted, nil
+		}
+		return QuestUnavailableExpired, nil
+	}
+	if quest.EndDate != nil && quest.EndDate.Before(occurrence.End) {
+		occurrence.End = *quest.EndDate
+	}
+	return "", &occurrence
+}
+
+// annotateQuest fills in a quest's current occurrence and whether it is
+// featured. One-off quests with an end date get a single occurrence so
+// clients can show the time remaining either way.
+func (h *Handler) annotateQuest(quest *models.Quest, now time.Time, featured map[uint]bool) {
+	quest.Featured = featured[quest.ID]
+
+	reason, occurrence := h.questAvailability(quest, now)
+	switch {
+	case reason != "":
+		return
+	case occurrence != nil:
+		quest.Occurrence = &models.QuestOccurrence{
+			Index:    occurrence.Index,
+			StartsAt: occurrence.Start,
+			EndsAt:   occurrence.End,
+		}
+	case quest.EndDate != nil:
+		start := quest.CreatedAt
+		if quest.StartDate != nil {
+			start = *quest.StartDate
+		}
+		quest.Occurrence = &models.QuestOccurrence{StartsAt: start, EndsAt: *quest.EndDate}
+	default:
+		return
+	}
+	quest.Occurrence.RemainingSeconds = int64(quest.Occurrence.EndsAt.Sub(now).Seconds())
+}
+
+// location returns the time zone recurring quests and featured days are
+// reckoned in
+func (h *Handler) location() *time.Location {
+	if h.cfg.Location == nil {
+		return time.Local
+	}
+	return h.cfg.Location
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// refreshReuseGrace is how long a just-rotated refresh token is treated as
+// a concurrent refresh, such as two tabs refreshing at once, rather than a
+// copied token being replayed
+const refreshReuseGrace = 30 * time.Second
+
+var (
+	// errSessionInvalid is returned for unknown, expired or revoked sessions
+	errSessionInvalid = errors.New("session is invalid")
+	// errRefreshRaced is returned when another request refreshed the same
+	// token first
+	errRefreshRaced = errors.New("refresh token was just rotated")
+	// errRefreshReused is returned when a rotated-out token is replayed
+	errRefreshReused = errors.New("refresh token was reused")
+)
+
+// Session Handlers
+
+// RefreshToken exchanges a refresh token for a new access token, rotating
+// the refresh token. Presenting an already-rotated token revokes the session,
+// since it means the token was copied, unless it was rotated moments ago by
+// a concurrent refresh.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeJSONError(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	hash := hashToken(req.RefreshToken)
+
+	var response AuthResponse
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		err := tx.Where("refresh_token_hash = ?", hash).First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return rotatedTokenError(tx, hash)
+		}
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			return errSessionInvalid
+		}
+
+		var user models.User
+		if err := tx.First(&user, session.UserID).Error; err != nil || !user.IsActive {
+			return errSessionInvalid
+		}
+
+		refreshToken, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&session).Where("refresh_token_hash = ?", hash).Updates(map[string]interface{}{
+			"refresh_token_hash":  hashToken(refreshToken),
+			"previous_token_hash": hash,
+			"last_used_at":        &now,
+			"expires_at":          now.Add(h.cfg.RefreshTokenTTL),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Lost a race with a concurrent refresh of the same token
+			return errRefreshRaced
+		}
+
+		token, err := h.generateJWT(user.ID, user.Role, session.ID)
+		if err != nil {
+			return err
+		}
+		response = AuthResponse{Token: token, RefreshToken: refreshToken, User: user}
+		return nil
+	})
+	if errors.Is(err, errRefreshReused) {
+		// A rotated-out token being replayed: revoke the whole session
+		now := time.Now()
+		h.db.Model(&models.Session{}).
+			Where("previous_token_hash = ? AND revoked_at IS NULL", hash).
+			Update("revoked_at", &now)
+		err = errSessionInvalid
+	}
+	if errors.Is(err, errRefreshRaced) {
+		// The session is still live; the client should use the token the
+		// concurrent refresh received
+		writeJSONErrorCode(w, "Refresh token was just rotated", "refresh_in_progress", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errSessionInvalid) {
+		writeJSONError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// rotatedTokenError tells a token that was rotated out moments ago by a
+// concurrent refresh apart from a copied token being replayed later
+func rotatedTokenError(tx *gorm.DB, hash string) error {
+	var session models.Session
+	err := tx.Where("previous_token_hash = ? AND revoked_at IS NULL", hash).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errSessionInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if session.LastUsedAt != nil && time.Since(*session.LastUsedAt) < refreshReuseGrace {
+		return errRefreshRaced
+	}
+	return errRefreshReused
+}
+
+// Logout revokes the current session
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Context().Value("session_id").(uint)
+
+	now := time.Now()
+	if err := h.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", &now).Error; err != nil {
+		writeJSONError(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Logged out successfully"}, http.StatusOK)
+}
+
+// LogoutAll revokes every session of the current user, logging out all devices
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	if err := revokeUserSessions(h.db, userID); err != nil {
+		writeJSONError(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Logged out of all devices"}, http.StatusOK)
+}
+
+// createSession starts a new session for a user who has just authenticated
+// with method and returns the access and refresh tokens for it
+func (h *Handler) createSession(user *models.User, r *http.Request, method string) (AuthResponse, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refreshToken),
+		ExpiresAt:        time.Now().Add(h.cfg.RefreshTokenTTL),
+		LoginMethod:      method,
+		UserAgent:        r.UserAgent(),
+		IP:               clientIP(r),
+	}
+	if err := h.db.Create(&session).Error; err != nil {
+		return AuthResponse{}, err
+	}
+
+	token, err := h.generateJWT(user.ID, user.Role, session.ID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{Token: token, RefreshToken: refreshToken, User: *user}, nil
+}
+
+// sessionStatus is what AuthMiddleware learns about a token's session
+type sessionStatus struct {
+	Active           bool   // Unrevoked, unexpired and belonging to an active user
+	Role             string // Current role, which may have changed since the token was issued
+	EmailVerified    bool
+	TwoFactorEnabled bool // The account has two-factor authentication
+	TwoFactorLogin   bool // This session's login was completed with a two-factor code
+}
+
+// checkSession looks up the session and user behind an access token
+func (h *Handler) checkSession(sessionID, userID uint) (sessionStatus, error) {
+	var row struct {
+		Role               string
+		EmailVerifiedAt    *time.Time
+		TwoFactorEnabledAt *time.Time
+		LoginMethod        string
+	}
+	result := h.db.Model(&models.Session{}).
+		Select("users.role, users.email_verified_at, users.two_factor_enabled_at, sessions.login_method").
+		Joins("JOIN users ON users.id = sessions.user_id AND users.deleted_at IS NULL").
+		Where("sessions.id = ? AND sessions.user_id = ?", sessionID, userID).
+		Where("sessions.revoked_at IS NULL AND sessions.expires_at > ? AND users.is_active = ?", time.Now(), true).
+		Limit(1).
+		Scan(&row)
+	if result.Error != nil {
+		return sessionStatus{}, result.Error
+	}
+
+	return sessionStatus{
+		Active:           result.RowsAffected > 0,
+		Role:             row.Role,
+		EmailVerified:    row.EmailVerifiedAt != nil,
+		TwoFactorEnabled: row.TwoFactorEnabledAt != nil,
+		TwoFactorLogin:   row.TwoFactorEnabledAt != nil && row.LoginMethod == models.LoginMethodTwoFactor,
+	}, nil
+}
+
+// revokeUserSessions revokes every active session of a user
+func revokeUserSessions(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	return tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", &now).Error
+}
+
+// revokeOtherSessions revokes every active session of a user except keep
+func revokeOtherSessions(tx *gorm.DB, userID, keep uint) error {
+	now := time.Now()
+	return tx.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Update("revoked_at", &now).Error
+}
+
+// hashToken returns the SHA-256 hex digest stored in place of a secret token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the remote address of the request without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,16 +1,25 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"gorm.io/gorm"
+
 	"koinonia-backend/models"
 )
 
+// errAlreadyReviewed is returned when a submission is no longer pending
+var errAlreadyReviewed = errors.New("submission already reviewed")
+
 // Submission Handlers
 
-// GetSubmissions returns all submissions (admin only)
+// GetSubmissions returns all submissions (admin only). Image submissions
+// include thumbnail and preview URLs so review lists needn't load the
+// full-size media.
 func (h *Handler) GetSubmissions(w http.ResponseWriter, r *http.Request) {
 	// Query parameters for filtering
 	status := r.URL.Query().Get("status")
@@ -39,10 +48,345 @@ func (h *Handler) GetSubmissions(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
 		return
 	}
+	if err := h.attachUploadVariants(submissions); err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
 
 	writeJSON(w, submissions, http.StatusOK)
 }
 
+// SubmissionsResponse is a page of submissions
+type SubmissionsResponse struct {
+	Total       int64               `json:"total"`
+	Submissions []models.Submission `json:"submissions"`
+}
+
+// GetMySubmissions returns the caller's own submissions, newest first,
+// filtered by status, quest_id and a from/to date range
+func (h *Handler) GetMySubmissions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	params := r.URL.Query()
+
+	query := h.db.Model(&models.Submission{}).Where("user_id = ?", userID)
+	if status := models.SubmissionStatus(params.Get("status")); status != "" {
+		switch status {
+		case models.SubmissionStatusPending, models.SubmissionStatusApproved,
+			models.SubmissionStatusRejected, models.SubmissionStatusWithdrawn:
+			query = query.Where("status = ?", status)
+		default:
+			writeJSONError(w, "status must be pending, approved, rejected or withdrawn", http.StatusBadRequest)
+			return
+		}
+	}
+	if questID := params.Get("quest_id"); questID != "" {
+		id, err := strconv.ParseUint(questID, 10, 32)
+		if err != nil {
+			writeJSONError(w, "quest_id must be a number", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("quest_id = ?", uint(id))
+	}
+
+	from, err := parseTimeParam(params.Get("from"))
+	if err != nil {
+		writeJSONError(w, "from must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(params.Get("to"))
+	if err != nil {
+		writeJSONError(w, "to must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+
+	limit, offset := parsePagination(r, 20, 100)
+	response := SubmissionsResponse{Submissions: []models.Submission{}}
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&response.Total).Error; err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
+	if err := query.Preload("User").Preload("Quest").Preload("ReviewedBy").
+		Order("created_at DESC, id DESC").Limit(limit).Offset(offset).
+		Find(&response.Submissions).Error; err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.attachUploadVariants(response.Submissions); err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
+	for i := range response.Submissions {
+		h.redactReviewer(r, &response.Submissions[i])
+	}
+	writeJSON(w, response, http.StatusOK)
+}
+
+// GetSubmission returns a single submission to its owner or a reviewer
+func (h *Handler) GetSubmission(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	submissionID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid submission ID", http.StatusBadRequest)
+		return
+	}
+
+	// Other users' submissions are reported missing rather than forbidden,
+	// so IDs can't be probed
+	var submission models.Submission
+	err = h.db.Preload("User").Preload("Quest").Preload("ReviewedBy").First(&submission, submissionID).Error
+	if err != nil || (submission.UserID != userID && !hasPermission(r, PermSubmissionsReview)) {
+		writeJSONError(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+
+	submissions := []models.Submission{submission}
+	if err := h.attachUploadVariants(submissions); err != nil {
+		writeJSONError(w, "Failed to fetch submission", http.StatusInternalServerError)
+		return
+	}
+	h.redactReviewer(r, &submissions[0])
+	writeJSON(w, submissions[0], http.StatusOK)
+}
+
+// redactReviewer hides who reviewed a submission from callers who can't
+// review submissions themselves, unless ShowReviewerToUsers is set. Even
+// then they only see the reviewer's public profile.
+func (h *Handler) redactReviewer(r *http.Request, submission *models.Submission) {
+	if hasPermission(r, PermSubmissionsReview) {
+		return
+	}
+	if !h.cfg.ShowReviewerToUsers {
+		submission.ReviewedByID = nil
+		submission.ReviewedBy = nil
+		return
+	}
+	if reviewer := submission.ReviewedBy; reviewer != nil {
+		submission.ReviewedBy = &models.User{
+			ID:        reviewer.ID,
+			Username:  reviewer.Username,
+			FirstName: reviewer.FirstName,
+			LastName:  reviewer.LastName,
+			Avatar:    reviewer.Avatar,
+		}
+	}
+}
+
+// UpdateSubmission lets the owner of a pending submission change its
+// content or media while the quest, or its occurrence, is still open. The
+// edit is graded again like a new submission.
+func (h *Handler) UpdateSubmission(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	submissionID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid submission ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Content  string `json:"content"`
+		MediaURL string `json:"media_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var submission models.Submission
+	if err := h.db.Preload("Quest").Where("id = ? AND user_id = ?", submissionID, userID).First(&submission).Error; err != nil {
+		writeJSONError(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+	if submission.Status != models.SubmissionStatusPending {
+		writeJSONError(w, "Only pending submissions can be edited", http.StatusConflict)
+		return
+	}
+
+	// Answers can't be changed once the quest or occurrence has closed
+	quest := submission.Quest
+	if quest.ID == 0 || !quest.IsActive {
+		writeJSONError(w, "Quest not found or inactive", http.StatusNotFound)
+		return
+	}
+	reason, occurrence := h.questAvailability(&quest, time.Now())
+	if reason == "" && occurrence != nil &&
+		!sameOccurrence(&models.QuestOccurrence{StartsAt: occurrence.Start}, submission.OccurrenceStart) {
+		reason = QuestUnavailableExpired
+	}
+	if reason != "" {
+		writeQuestUnavailable(w, reason)
+		return
+	}
+
+	submission.Content = req.Content
+	submission.MediaType, submission.ThumbnailURL, submission.PreviewURL = "", "", ""
+	if !h.attachMedia(w, userID, &submission, req.MediaURL) {
+		return
+	}
+
+	submission.Accuracy, submission.GradingDiff = nil, ""
+	verdict, notes, err := h.gradeSubmission(&quest, &submission)
+	if errors.Is(err, errInvalidTriviaOption) {
+		writeJSONError(w, "Answer must be one of the quest's options", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to grade submission", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Guard on the pending status so an edit can't race a review
+		result := tx.Model(&models.Submission{}).
+			Where("id = ? AND status = ?", submission.ID, models.SubmissionStatusPending).
+			Updates(map[string]interface{}{
+				"content":       submission.Content,
+				"media_url":     submission.MediaURL,
+				"media_type":    submission.MediaType,
+				"thumbnail_url": submission.ThumbnailURL,
+				"preview_url":   submission.PreviewURL,
+				"accuracy":      submission.Accuracy,
+				"grading_diff":  submission.GradingDiff,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errAlreadyReviewed
+		}
+		return applyVerdict(tx, &submission, &quest, verdict, notes)
+	})
+	if errors.Is(err, errAlreadyReviewed) {
+		writeJSONError(w, "Only pending submissions can be edited", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to update submission", http.StatusInternalServerError)
+		return
+	}
+
+	h.db.Preload("User").Preload("Quest").First(&submission, submission.ID)
+	writeJSON(w, submission, http.StatusOK)
+}
+
+// WithdrawSubmission lets the owner take back a pending submission. It is
+// kept as withdrawn and no longer counts toward the quest's limits.
+func (h *Handler) WithdrawSubmission(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	submissionID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid submission ID", http.StatusBadRequest)
+		return
+	}
+
+	var submission models.Submission
+	if err := h.db.Where("id = ? AND user_id = ?", submissionID, userID).First(&submission).Error; err != nil {
+		writeJSONError(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+
+	result := h.db.Model(&models.Submission{}).
+		Where("id = ? AND status = ?", submission.ID, models.SubmissionStatusPending).
+		Update("status", models.SubmissionStatusWithdrawn)
+	if result.Error != nil {
+		writeJSONError(w, "Failed to withdraw submission", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeJSONError(w, "Only pending submissions can be withdrawn", http.StatusConflict)
+		return
+	}
+
+	h.db.Preload("User").Preload("Quest").First(&submission, submission.ID)
+	writeJSON(w, submission, http.StatusOK)
+}
+
+// GetSubmissionHistory returns every attempt in a submission's chain of
+// retries, first attempt first, each with its own review. Like
+// GetSubmission it is limited to the owner and reviewers.
+func (h *Handler) GetSubmissionHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	submissionID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid submission ID", http.StatusBadRequest)
+		return
+	}
+
+	var submission models.Submission
+	if err := h.db.First(&submission, submissionID).Error; err != nil ||
+		(submission.UserID != userID && !hasPermission(r, PermSubmissionsReview)) {
+		writeJSONError(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+
+	// Walk back to the first attempt, then forward through every retry
+	var ids []uint
+	err = h.db.Raw(`WITH RECURSIVE earlier AS (
+			SELECT id, previous_submission_id FROM submissions WHERE id = ?
+			UNION
+			SELECT s.id, s.previous_submission_id FROM submissions s JOIN earlier e ON s.id = e.previous_submission_id
+		), attempts AS (
+			SELECT id FROM earlier WHERE previous_submission_id IS NULL
+			UNION
+			SELECT s.id FROM submissions s JOIN attempts a ON s.previous_submission_id = a.id
+		)
+		SELECT id FROM attempts`, submission.ID).Scan(&ids).Error
+	if err != nil {
+		writeJSONError(w, "Failed to fetch submission history", http.StatusInternalServerError)
+		return
+	}
+	if len(ids) == 0 {
+		ids = []uint{submission.ID} // An earlier attempt has been purged
+	}
+
+	attempts := []models.Submission{}
+	if err := h.db.Preload("Quest").Preload("ReviewedBy").Where("id IN ?", ids).
+		Order("attempt, created_at, id").Find(&attempts).Error; err != nil {
+		writeJSONError(w, "Failed to fetch submission history", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.attachUploadVariants(attempts); err != nil {
+		writeJSONError(w, "Failed to fetch submission history", http.StatusInternalServerError)
+		return
+	}
+	for i := range attempts {
+		h.redactReviewer(r, &attempts[i])
+	}
+	writeJSON(w, attempts, http.StatusOK)
+}
+
+// attachMedia sets a submission's media from one of the user's uploads,
+// or clears it when mediaURL is empty. It writes an error response and
+// returns false if the upload can't be used.
+func (h *Handler) attachMedia(w http.ResponseWriter, userID uint, submission *models.Submission, mediaURL string) bool {
+	submission.MediaURL = mediaURL
+	if mediaURL == "" {
+		return true
+	}
+
+	upload, err := h.findOwnedUpload(userID, mediaURL)
+	if errors.Is(err, errUploadNotOwned) {
+		writeJSONError(w, "Media URL must be a file you uploaded", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to verify media", http.StatusInternalServerError)
+		return false
+	}
+	submission.MediaType = upload.MediaType
+	submission.ThumbnailURL = upload.ThumbnailURL
+	submission.PreviewURL = upload.PreviewURL
+	return true
+}
+
 // ApproveSubmission approves a quest submission and awards points
 func (h *Handler) ApproveSubmission(w http.ResponseWriter, r *http.Request) {
 	submissionID, err := parseID(r, "id")
@@ -66,35 +410,23 @@ func (h *Handler) ApproveSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Start database transaction
-	tx := h.db.Begin()
-
-	// Update submission status
-	now := time.Now()
-	updates := map[string]interface{}{
-		"status":          models.SubmissionStatusApproved,
-		"points_awarded":  submission.Quest.Points,
-		"reviewed_at":     &now,
-		"reviewed_by_id":  adminID,
-	}
-
-	if err := tx.Model(&submission).Updates(updates).Error; err != nil {
-		tx.Rollback()
-		writeJSONError(w, "Failed to update submission", http.StatusInternalServerError)
+	// Approve, award points and audit in a single transaction
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before := submissionAuditState(&submission)
+		if err := approveSubmission(tx, &submission, submission.Quest.Points, &adminID, ""); err != nil {
+			return err
+		}
+		return auditSubmissionReview(tx, r, auditSubmissionApprove, submissionID, before)
+	})
+	if errors.Is(err, errAlreadyReviewed) {
+		writeJSONError(w, "Submission already reviewed", http.StatusBadRequest)
 		return
 	}
-
-	// Award points to user
-	if err := tx.Model(&models.User{}).Where("id = ?", submission.UserID).
-		UpdateColumn("total_points", h.db.Raw("total_points + ?", submission.Quest.Points)).Error; err != nil {
-		tx.Rollback()
-		writeJSONError(w, "Failed to award points", http.StatusInternalServerError)
+	if err != nil {
+		writeJSONError(w, "Failed to approve submission", http.StatusInternalServerError)
 		return
 	}
 
-	// Commit transaction
-	tx.Commit()
-
 	// Return updated submission
 	h.db.Preload("User").Preload("Quest").Preload("ReviewedBy").First(&submission, submissionID)
 	writeJSON(w, submission, http.StatusOK)
@@ -130,15 +462,18 @@ func (h *Handler) RejectSubmission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update submission status
-	now := time.Now()
-	updates := map[string]interface{}{
-		"status":         models.SubmissionStatusRejected,
-		"admin_notes":    req.AdminNotes,
-		"reviewed_at":    &now,
-		"reviewed_by_id": adminID,
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before := submissionAuditState(&submission)
+		if err := rejectSubmission(tx, &submission, &adminID, req.AdminNotes); err != nil {
+			return err
+		}
+		return auditSubmissionReview(tx, r, auditSubmissionReject, submissionID, before)
+	})
+	if errors.Is(err, errAlreadyReviewed) {
+		writeJSONError(w, "Submission already reviewed", http.StatusBadRequest)
+		return
 	}
-
-	if err := h.db.Model(&submission).Updates(updates).Error; err != nil {
+	if err != nil {
 		writeJSONError(w, "Failed to update submission", http.StatusInternalServerError)
 		return
 	}
@@ -147,3 +482,95 @@ func (h *Handler) RejectSubmission(w http.ResponseWriter, r *http.Request) {
 	h.db.Preload("User").Preload("Quest").Preload("ReviewedBy").First(&submission, submissionID)
 	writeJSON(w, submission, http.StatusOK)
 }
+
+// approveSubmission marks a submission approved and credits the points to its
+// owner. It must be called inside a transaction; reviewerID is nil when the
+// submission was graded automatically.
+func approveSubmission(tx *gorm.DB, submission *models.Submission, points int, reviewerID *uint, notes string) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":         models.SubmissionStatusApproved,
+		"points_awarded": points,
+		"admin_notes":    notes,
+		"reviewed_at":    &now,
+		"reviewed_by_id": reviewerID,
+	}
+
+	// Guard on the pending status so concurrent reviews can't award twice
+	result := tx.Model(submission).Where("status = ?", models.SubmissionStatusPending).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAlreadyReviewed
+	}
+
+	// Award points to user
+	err := recordPoints(tx, &models.PointTransaction{
+		UserID:       submission.UserID,
+		Amount:       points,
+		Reason:       models.PointReasonSubmissionApproved,
+		SubmissionID: &submission.ID,
+		AdminID:      reviewerID,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The approval may finish a chain and earn its bonus
+	return completeChain(tx, submission)
+}
+
+// applyVerdict approves or rejects a submission that was graded
+// automatically; pending submissions are left for a reviewer
+func applyVerdict(tx *gorm.DB, submission *models.Submission, quest *models.Quest, verdict models.SubmissionStatus, notes string) error {
+	switch verdict {
+	case models.SubmissionStatusApproved:
+		return approveSubmission(tx, submission, quest.Points, nil, notes)
+	case models.SubmissionStatusRejected:
+		return rejectSubmission(tx, submission, nil, notes)
+	}
+	return nil
+}
+
+// rejectSubmission marks a submission rejected with the given notes
+func rejectSubmission(tx *gorm.DB, submission *models.Submission, reviewerID *uint, notes string) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":         models.SubmissionStatusRejected,
+		"admin_notes":    notes,
+		"reviewed_at":    &now,
+		"reviewed_by_id": reviewerID,
+	}
+
+	result := tx.Model(submission).Where("status = ?", models.SubmissionStatusPending).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAlreadyReviewed
+	}
+	return nil
+}
+
+// submissionAuditState is the part of a submission that reviews change,
+// snapshotted for the audit log
+func submissionAuditState(submission *models.Submission) map[string]interface{} {
+	return map[string]interface{}{
+		"status":         submission.Status,
+		"points_awarded": submission.PointsAwarded,
+		"admin_notes":    submission.AdminNotes,
+		"reviewed_by_id": submission.ReviewedByID,
+		"reviewed_at":    submission.ReviewedAt,
+	}
+}
+
+// auditSubmissionReview records a review with the submission's state before
+// and after it
+func auditSubmissionReview(tx *gorm.DB, r *http.Request, action string, submissionID uint, before map[string]interface{}) error {
+	var after models.Submission
+	if err := tx.First(&after, submissionID).Error; err != nil {
+		return err
+	}
+	return recordAudit(tx, r, action, auditTargetSubmission, submissionID, before, submissionAuditState(&after))
+}
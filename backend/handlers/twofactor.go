@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+	"koinonia-backend/totp"
+)
+
+const (
+	// twoFactorLoginAudience marks tokens that can only complete a login's
+	// second step
+	twoFactorLoginAudience = "two-factor-login"
+
+	// twoFactorChallengeTTL is how long the user has to enter their code
+	twoFactorChallengeTTL = 5 * time.Minute
+
+	// twoFactorIssuer labels the account in authenticator apps
+	twoFactorIssuer = "Koinonia"
+
+	recoveryCodeCount = 10
+)
+
+// TwoFactorChallengeResponse is returned instead of tokens when a login
+// needs a second factor
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"` // Exchanged with a code at /api/auth/2fa/verify
+}
+
+// TwoFactorSetupResponse holds a new TOTP secret for the user's authenticator app
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI to render as a QR code
+}
+
+// RecoveryCodesResponse lists freshly generated recovery codes. They are
+// only ever shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Two-Factor Authentication Handlers
+
+// VerifyTwoFactorLogin completes a login by checking a TOTP or recovery
+// code against the challenge token Login returned
+func (h *Handler) VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TwoFactorToken string `json:"two_factor_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(req.TwoFactorToken, claims, h.cfg.Keys.keyFunc,
+		jwt.WithAudience(twoFactorLoginAudience))
+	if err != nil || !token.Valid {
+		writeJSONError(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		writeJSONError(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil || !user.IsActive || user.TwoFactorEnabledAt == nil {
+		writeJSONError(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	// Six-digit codes are easy to guess without a limit on attempts
+	lockoutKey := twoFactorLockoutKey(user.ID)
+	attempt, wait, err := h.reserveLoginAttempt(r, lockoutKey)
+	if err != nil {
+		writeJSONError(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if !h.checkSecondFactor(&user, req.Code, req.RecoveryCode) {
+		attempt.failed(h, r, &user.ID)
+		writeJSONError(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+	attempt.succeeded(h, r)
+
+	// Update last login
+	now := time.Now()
+	h.db.Model(&user).Update("last_login", &now)
+
+	// Start a session and generate tokens
+	response, err := h.createSession(&user, r, models.LoginMethodTwoFactor)
+	if err != nil {
+		writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// SetupTwoFactor generates a new TOTP secret. It takes effect once confirmed
+// with a code through EnableTwoFactor.
+func (h *Handler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TwoFactorEnabledAt != nil {
+		writeJSONError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		writeJSONError(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.Model(&user).Update("two_factor_secret", secret).Error; err != nil {
+		writeJSONError(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.URI(secret, twoFactorIssuer, user.Email),
+	}, http.StatusOK)
+}
+
+// EnableTwoFactor turns on two-factor authentication after the user proves
+// their authenticator works, and returns their recovery codes. The user's
+// other sessions are revoked, since they were started without a code.
+func (h *Handler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+	sessionID := r.Context().Value("session_id").(uint)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TwoFactorEnabledAt != nil {
+		writeJSONError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TwoFactorSecret == "" {
+		writeJSONError(w, "Set up two-factor authentication first", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkTwoFactorAttempt(w, r, &user, http.StatusBadRequest, func() bool { return h.useTOTPCode(&user, req.Code) }) {
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&user).Update("two_factor_enabled_at", &now).Error; err != nil {
+			return err
+		}
+		if err := revokeOtherSessions(tx, user.ID, sessionID); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, RecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// DisableTwoFactor turns off two-factor authentication. It needs both the
+// password and a current code so a stolen session alone can't remove it.
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TwoFactorEnabledAt == nil {
+		writeJSONError(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		writeJSONError(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if !h.checkTwoFactorAttempt(w, r, &user, http.StatusUnauthorized, func() bool { return h.checkSecondFactor(&user, req.Code, req.RecoveryCode) }) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_secret":     "",
+			"two_factor_enabled_at": nil,
+			"two_factor_last_step":  0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		writeJSONError(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Two-factor authentication disabled"}, http.StatusOK)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TwoFactorEnabledAt == nil {
+		writeJSONError(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if !h.checkTwoFactorAttempt(w, r, &user, http.StatusUnauthorized, func() bool { return h.useTOTPCode(&user, req.Code) }) {
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, RecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// twoFactorChallenge signs a short-lived token proving the user passed the
+// first login step
+func (h *Handler) twoFactorChallenge(user *models.User) (string, error) {
+	now := time.Now()
+	return h.cfg.Keys.sign(jwt.RegisteredClaims{
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Audience:  jwt.ClaimStrings{twoFactorLoginAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallengeTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	})
+}
+
+// checkTwoFactorAttempt runs check, a two-factor code check for a logged-in
+// user, under the same attempt limit as logging in with a code, so a stolen
+// session can't guess codes. It writes an error response, with failStatus
+// for a wrong code, and returns false if the attempt doesn't pass.
+func (h *Handler) checkTwoFactorAttempt(w http.ResponseWriter, r *http.Request, user *models.User, failStatus int, check func() bool) bool {
+	attempt, wait, err := h.reserveLoginAttempt(r, twoFactorLockoutKey(user.ID))
+	if err != nil {
+		writeJSONError(w, "Failed to check two-factor attempts", http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return false
+	}
+
+	if !check() {
+		attempt.failed(h, r, &user.ID)
+		writeJSONError(w, "Invalid two-factor code", failStatus)
+		return false
+	}
+	attempt.succeeded(h, r)
+	return true
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code
+func (h *Handler) checkSecondFactor(user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		return h.useTOTPCode(user, code)
+	}
+	if recoveryCode != "" {
+		return h.useRecoveryCode(user.ID, recoveryCode)
+	}
+	return false
+}
+
+// useTOTPCode validates a TOTP code and records its time step, so the same
+// code can't be used twice even by concurrent requests
+func (h *Handler) useTOTPCode(user *models.User, code string) bool {
+	step, ok := totp.Validate(user.TwoFactorSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	result := h.db.Model(&models.User{}).
+		Where("id = ? AND two_factor_last_step < ?", user.ID, step).
+		Update("two_factor_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// useRecoveryCode marks a recovery code as used if it is valid
+func (h *Handler) useRecoveryCode(userID uint, code string) bool {
+	result := h.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and creates new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		token, err := randomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = token[:5] + "-" + token[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(token)}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode strips the separators users may type or omit
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"regexp"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// dryRunDB returns a database that builds statements without running them
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReplaceRecoveryCodes(t *testing.T) {
+	db := dryRunDB(t)
+
+	var created []models.RecoveryCode
+	err := db.Callback().Create().After("gorm:create").Register("test:capture", func(tx *gorm.DB) {
+		if records, ok := tx.Statement.Dest.(*[]models.RecoveryCode); ok {
+			created = append(created, *records...)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := replaceRecoveryCodes(db, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(created) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d records, want %d", len(codes), len(created), recoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[0-9a-f]{5}-[0-9a-f]{5}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q isn't formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+
+		// Only the hash is stored, and it must match what users type back
+		if created[i].UserID != 7 || created[i].CodeHash != hashToken(normalizeRecoveryCode(code)) {
+			t.Errorf("record %d = %+v doesn't match code %q", i, created[i], code)
+		}
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcde-12345", "abcde12345"},
+		{"ABCDE-12345", "abcde12345"},
+		{"  abcde 12345 ", "abcde12345"},
+		{"abcde12345", "abcde12345"},
+		{"ab-cde-123-45", "abcde12345"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/media"
+	"koinonia-backend/models"
+)
+
+// allowedUploadTypes maps accepted content types to their file extensions
+var allowedUploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+	"audio/mpeg": ".mp3",
+	"audio/wave": ".wav",
+}
+
+// Resized image variants generated for each uploaded photo
+const (
+	imageVariantThumb   = "thumb"   // Small square-ish thumbnail for lists
+	imageVariantPreview = "preview" // Medium size for review screens
+)
+
+// imageOptions returns the processing settings for uploaded photos
+func imageOptions(maxDimension int) media.Options {
+	return media.Options{
+		MaxDimension: maxDimension,
+		MaxPixels:    50_000_000,
+		Variants: []media.Variant{
+			{Name: imageVariantThumb, MaxDimension: 320},
+			{Name: imageVariantPreview, MaxDimension: 1024},
+		},
+	}
+}
+
+// variantKey returns the storage key of a resized variant of an upload
+func variantKey(key, variant string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_" + variant + ext
+}
+
+// errUploadNotOwned is returned when a media URL is not one of the caller's uploads
+var errUploadNotOwned = errors.New("media URL is not an upload owned by the user")
+
+// Upload Handlers
+
+// UploadMedia stores a multipart "file" upload and returns its URL for use
+// as a submission's media_url
+func (h *Handler) UploadMedia(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	if h.cfg.Storage == nil {
+		writeJSONError(w, "Uploads are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Leave room for multipart headers on top of the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxUploadSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, "File is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeJSONError(w, "A multipart file field named \"file\" is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.cfg.MaxUploadSize+1))
+	if err != nil {
+		writeJSONError(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > h.cfg.MaxUploadSize {
+		writeJSONError(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Trust the file's contents rather than the client's declared type
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedUploadTypes[contentType]
+	if !ok {
+		writeJSONError(w, "Unsupported file type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	name, err := randomToken(16)
+	if err != nil {
+		writeJSONError(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+	base := fmt.Sprintf("uploads/%d/%s", userID, name)
+
+	upload := models.Upload{
+		UserID:    userID,
+		MediaType: strings.SplitN(contentType, "/", 2)[0],
+		Filename:  header.Filename,
+	}
+	original := media.Image{Data: data, ContentType: contentType, Ext: ext}
+	var variants map[string]media.Image
+
+	// Images are re-encoded to strip metadata and get resized variants
+	if upload.MediaType == "image" {
+		processed, err := media.ProcessImage(data, imageOptions(h.cfg.MaxImageDimension))
+		if errors.Is(err, media.ErrImageTooLarge) {
+			writeJSONError(w, "Image dimensions are too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, "Invalid image file", http.StatusBadRequest)
+			return
+		}
+		original, variants = processed.Original, processed.Variants
+	}
+
+	// Store the file and any variants, removing what was stored if anything fails
+	var stored []string
+	cleanup := func() {
+		for _, key := range stored {
+			h.cfg.Storage.Delete(r.Context(), key)
+		}
+	}
+
+	upload.Key = base + original.Ext
+	upload.URL = h.cfg.Storage.URL(upload.Key)
+	upload.ContentType = original.ContentType
+	upload.Size = int64(len(original.Data))
+	if err := h.cfg.Storage.Put(r.Context(), upload.Key, original.Data, original.ContentType); err != nil {
+		writeJSONError(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+	stored = append(stored, upload.Key)
+
+	for name, variant := range variants {
+		key := variantKey(upload.Key, name)
+		if err := h.cfg.Storage.Put(r.Context(), key, variant.Data, variant.ContentType); err != nil {
+			cleanup()
+			writeJSONError(w, "Failed to store file", http.StatusInternalServerError)
+			return
+		}
+		stored = append(stored, key)
+
+		switch name {
+		case imageVariantThumb:
+			upload.ThumbnailURL = h.cfg.Storage.URL(key)
+		case imageVariantPreview:
+			upload.PreviewURL = h.cfg.Storage.URL(key)
+		}
+	}
+
+	if err := h.db.Create(&upload).Error; err != nil {
+		cleanup()
+		writeJSONError(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, upload, http.StatusCreated)
+}
+
+// findOwnedUpload looks up the caller's upload by its public URL
+func (h *Handler) findOwnedUpload(userID uint, mediaURL string) (*models.Upload, error) {
+	var upload models.Upload
+	err := h.db.Where("url = ? AND user_id = ?", mediaURL, userID).First(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errUploadNotOwned
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// attachUploadVariants fills in the thumbnail and preview URLs of image
+// submissions that lack them, such as those made before variants were
+// copied onto submissions, from their uploads in a single query
+func (h *Handler) attachUploadVariants(submissions []models.Submission) error {
+	var urls []string
+	for _, submission := range submissions {
+		if submission.MediaURL != "" && submission.ThumbnailURL == "" {
+			urls = append(urls, submission.MediaURL)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	var uploads []models.Upload
+	if err := h.db.Where("url IN ? AND thumbnail_url <> ''", urls).Find(&uploads).Error; err != nil {
+		return err
+	}
+	byURL := make(map[string]*models.Upload, len(uploads))
+	for i := range uploads {
+		byURL[uploads[i].URL] = &uploads[i]
+	}
+
+	for i := range submissions {
+		upload, ok := byURL[submissions[i].MediaURL]
+		if !ok || submissions[i].ThumbnailURL != "" || upload.UserID != submissions[i].UserID {
+			continue
+		}
+		submissions[i].ThumbnailURL = upload.ThumbnailURL
+		submissions[i].PreviewURL = upload.PreviewURL
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"koinonia-backend/models"
+)
+
+// errUserNotFound is returned when an admin action targets a missing user
+var errUserNotFound = errors.New("user not found")
+
+// AdminUsersResponse is a page of users matching the admin's filters
+type AdminUsersResponse struct {
+	Total int64         `json:"total"` // Number of matching users
+	Users []models.User `json:"users"`
+}
+
+// AdminUserDetail is a user with their submission history
+type AdminUserDetail struct {
+	User             models.User                       `json:"user"`
+	SubmissionCounts map[models.SubmissionStatus]int64 `json:"submission_counts"`
+	Submissions      []models.Submission               `json:"submissions"` // Most recent first
+}
+
+// User Management Handlers
+
+// GetUsers lists users for admins. Supports q (matches username, email or
+// name), role, active, last_login_after and last_login_before (which also
+// matches users who have never logged in), with limit and offset.
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r, 50, 100)
+	params := r.URL.Query()
+
+	query := h.db.Model(&models.User{})
+	if q := strings.TrimSpace(params.Get("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ? OR LOWER(first_name || ' ' || last_name) LIKE ?",
+			pattern, pattern, pattern)
+	}
+	if role := params.Get("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if active := params.Get("active"); active != "" {
+		isActive, err := strconv.ParseBool(active)
+		if err != nil {
+			writeJSONError(w, "active must be true or false", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("is_active = ?", isActive)
+	}
+
+	after, err := parseTimeParam(params.Get("last_login_after"))
+	if err != nil {
+		writeJSONError(w, "last_login_after must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	before, err := parseTimeParam(params.Get("last_login_before"))
+	if err != nil {
+		writeJSONError(w, "last_login_before must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if after != nil {
+		query = query.Where("last_login >= ?", *after)
+	}
+	if before != nil {
+		query = query.Where("last_login < ? OR last_login IS NULL", *before)
+	}
+
+	var response AdminUsersResponse
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&response.Total).Error; err != nil {
+		writeJSONError(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&response.Users).Error; err != nil {
+		writeJSONError(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// GetUser returns a user with their submission history
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	limit, offset := parsePagination(r, 50, 100)
+
+	detail := AdminUserDetail{SubmissionCounts: map[models.SubmissionStatus]int64{}}
+	if err := h.db.First(&detail.User, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var counts []struct {
+		Status models.SubmissionStatus
+		Count  int64
+	}
+	if err := h.db.Model(&models.Submission{}).Select("status, COUNT(*) as count").
+		Where("user_id = ?", userID).Group("status").Scan(&counts).Error; err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range counts {
+		detail.SubmissionCounts[c.Status] = c.Count
+	}
+
+	if err := h.db.Preload("Quest").Preload("ReviewedBy").
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).Offset(offset).
+		Find(&detail.Submissions).Error; err != nil {
+		writeJSONError(w, "Failed to fetch submissions", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, detail, http.StatusOK)
+}
+
+// SetUserActive deactivates or reactivates a user. Deactivating revokes
+// every session, so the user is logged out everywhere at once.
+func (h *Handler) SetUserActive(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(uint)
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Active *bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Active == nil {
+		writeJSONError(w, "active is required", http.StatusBadRequest)
+		return
+	}
+	if userID == adminID {
+		writeJSONError(w, "You cannot deactivate your own account", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("is_active", *req.Active).Error; err != nil {
+			return err
+		}
+		action := auditUserActivate
+		if !*req.Active {
+			action = auditUserDeactivate
+			if err := revokeUserSessions(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, action, auditTargetUser, userID, before, user)
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, user, http.StatusOK)
+}
+
+// AdjustUserPoints adds or removes points by hand. A reason is required and
+// is kept on the ledger entry.
+func (h *Handler) AdjustUserPoints(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(uint)
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Amount == 0 || req.Reason == "" {
+		writeJSONError(w, "A non-zero amount and a reason are required", http.StatusBadRequest)
+		return
+	}
+
+	entry := models.PointTransaction{
+		UserID:  userID,
+		Amount:  req.Amount,
+		Reason:  models.PointReasonAdminAdjustment,
+		Note:    req.Reason,
+		AdminID: &adminID,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := recordPoints(tx, &entry); err != nil {
+			return err
+		}
+
+		var after models.User
+		if err := tx.First(&after, userID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditUserPointsAdjust, auditTargetUser, userID,
+			map[string]interface{}{"total_points": before.TotalPoints},
+			map[string]interface{}{"total_points": after.TotalPoints, "amount": req.Amount, "reason": req.Reason, "transaction_id": entry.ID})
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to adjust points", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, entry, http.StatusCreated)
+}
+
+// SendUserPasswordReset emails a user a password reset link on an admin's
+// behalf. Admins never see or choose the new password.
+func (h *Handler) SendUserPasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// The email goes out only once the token is committed, so a rolled back
+	// reset never sends a link that doesn't work
+	var user *models.User
+	var token string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(tx, r, auditUserPasswordReset, auditTargetUser, userID, nil, nil); err != nil {
+			return err
+		}
+		token, err = h.createPasswordReset(tx, user)
+		return err
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to send password reset", http.StatusInternalServerError)
+		return
+	}
+	h.emailPasswordReset(user, token)
+
+	writeJSON(w, MessageResponse{Message: "Password reset email sent"}, http.StatusOK)
+}
+
+// lockUser loads a user for update, so concurrent admin actions on the same
+// user are applied one at a time and audited with accurate snapshots
+func lockUser(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
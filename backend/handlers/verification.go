@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"koinonia-backend/mail"
+	"koinonia-backend/models"
+)
+
+// emailVerificationAudience marks tokens that can only verify an email address
+const emailVerificationAudience = "email-verification"
+
+// emailVerificationClaims are the claims of a signed verification link. The
+// email is included so the link stops working if the address changes.
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Email Verification Handlers
+
+// VerifyEmail marks the user's email as verified using a signed link token
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeJSONError(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	claims := &emailVerificationClaims{}
+	token, err := jwt.ParseWithClaims(req.Token, claims, h.cfg.Keys.keyFunc,
+		jwt.WithAudience(emailVerificationAudience))
+	if err != nil || !token.Valid {
+		writeJSONError(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		writeJSONError(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil || user.Email != claims.Email {
+		writeJSONError(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		if err := h.db.Model(&user).Update("email_verified_at", &now).Error; err != nil {
+			writeJSONError(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, user, http.StatusOK)
+}
+
+// ResendVerification sends a new verification email, at most once per
+// configured interval
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(uint)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		writeJSONError(w, "Email is already verified", http.StatusBadRequest)
+		return
+	}
+
+	// Claim the send slot atomically so concurrent requests can't both send
+	now := time.Now()
+	cutoff := now.Add(-h.cfg.VerificationResendInterval)
+	result := h.db.Model(&models.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", userID, cutoff).
+		Update("verification_sent_at", &now)
+	if result.Error != nil {
+		writeJSONError(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		retryAfter := user.VerificationSentAt.Add(h.cfg.VerificationResendInterval).Sub(now)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		writeJSONError(w, "Please wait before requesting another verification email", http.StatusTooManyRequests)
+		return
+	}
+
+	if err := h.sendVerificationEmail(&user); err != nil {
+		writeJSONError(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, MessageResponse{Message: "Verification email sent"}, http.StatusOK)
+}
+
+// SetEmailVerification lets admins mark a user's email verified or unverified
+func (h *Handler) SetEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseID(r, "id")
+	if err != nil {
+		writeJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Verified bool `json:"verified"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var verifiedAt *time.Time
+	if req.Verified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	var user models.User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("email_verified_at", verifiedAt).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, r, auditUserEmailVerification, auditTargetUser, userID, before, user)
+	})
+	if errors.Is(err, errUserNotFound) {
+		writeJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to update verification", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, user, http.StatusOK)
+}
+
+// sendVerificationEmail emails the user a signed verification link. The
+// email is sent in the background; only signing errors are returned.
+func (h *Handler) sendVerificationEmail(user *models.User) error {
+	now := time.Now()
+	token, err := h.cfg.Keys.sign(emailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(h.cfg.VerificationTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Koinonia email",
+		Body: fmt.Sprintf("Hi %s,\n\nWelcome to Koinonia! Please confirm your email address so you can submit quests and join the leaderboard:\n\n%s/verify-email?token=%s\n\nThis link expires in %s.\n",
+			user.Username, h.cfg.AppURL, url.QueryEscape(token), h.cfg.VerificationTTL),
+	}
+	go func() {
+		if err := h.cfg.Mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// State is the failed-attempt history of one key
+type State struct {
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// Store counts failed attempts per key, such as "user:12" or "ip:203.0.113.7".
+// Keys are only stored while they have failures inside the window, so
+// traffic from many clients or names doesn't grow the store without bound.
+type Store interface {
+	// Update atomically replaces key's state with the one fn returns, unless
+	// fn returns false. Failures before since are forgotten before fn sees
+	// the state, and a key left with no failures is removed. It returns the
+	// state as left.
+	Update(ctx context.Context, key string, since time.Time, fn func(State) (State, bool)) (State, error)
+
+	// Reset forgets every failure of key
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy decides how long a key must wait after failing
+type Policy struct {
+	FreeAttempts int           // Failures allowed before any delay
+	BaseDelay    time.Duration // Delay after the first failure past FreeAttempts, doubling with each further failure
+	MaxDelay     time.Duration // Longest delay; reaching it locks the key out
+	Window       time.Duration // Failures are forgotten once the last one is this old
+}
+
+// delay returns how long to wait after the given number of failures
+func (p Policy) delay(failures int) time.Duration {
+	excess := failures - p.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < excess && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Result describes a key after a failed attempt
+type Result struct {
+	Failures   int
+	RetryAfter time.Duration // How long until the key may try again
+	Locked     bool          // True when this failure locked the key out
+	LockedTill time.Time     // When the lockout ends, if Locked
+}
+
+// Limiter applies a backoff policy to the failures counted in a store
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+// NewLimiter creates a limiter
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Reserve counts an attempt for key as failed before it is verified, so
+// concurrent attempts can't all pass a check before any failure is recorded.
+// If key must wait, nothing is counted and the wait is returned. Otherwise
+// the Result describes key should the attempt fail; callers Reset or
+// Release the reservation when it succeeds.
+func (l *Limiter) Reserve(ctx context.Context, key string) (time.Duration, Result, error) {
+	now := time.Now()
+	var wait time.Duration
+	state, err := l.store.Update(ctx, key, now.Add(-l.policy.Window), func(state State) (State, bool) {
+		if state.Failures > 0 {
+			if wait = state.LastFailureAt.Add(l.policy.delay(state.Failures)).Sub(now); wait > 0 {
+				return state, false
+			}
+		}
+		state.Failures++
+		state.LastFailureAt = now
+		return state, true
+	})
+	if err != nil || wait > 0 {
+		return wait, Result{}, err
+	}
+
+	delay := l.policy.delay(state.Failures)
+	locked := delay == l.policy.MaxDelay && l.policy.delay(state.Failures-1) < l.policy.MaxDelay
+	result := Result{Failures: state.Failures, RetryAfter: delay, Locked: locked}
+	if locked {
+		result.LockedTill = now.Add(delay)
+	}
+	return 0, result, nil
+}
+
+// Release takes back a reservation whose attempt succeeded without
+// clearing key's earlier failures, e.g. for a shared client IP
+func (l *Limiter) Release(ctx context.Context, key string) error {
+	_, err := l.store.Update(ctx, key, time.Now().Add(-l.policy.Window), func(state State) (State, bool) {
+		if state.Failures == 0 {
+			return state, false
+		}
+		state.Failures--
+		return state, true
+	})
+	return err
+}
+
+// Reset clears key's failures, e.g. after a successful login
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
@@ -0,0 +1,176 @@
+package lockout
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Window: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyDelayWithoutFreeAttempts(t *testing.T) {
+	policy := Policy{BaseDelay: time.Minute, MaxDelay: time.Minute}
+	if got := policy.delay(1); got != time.Minute {
+		t.Errorf("delay(1) = %v, want %v", got, time.Minute)
+	}
+}
+
+// testPolicy throttles after two free attempts and locks out on the fourth
+// failure. Delays are long enough that the tests never wait them out.
+var testPolicy = Policy{FreeAttempts: 2, BaseDelay: time.Hour, MaxDelay: 2 * time.Hour, Window: 24 * time.Hour}
+
+// failures returns how many failures the store holds for key
+func failures(t *testing.T, store Store, key string) int {
+	t.Helper()
+	state, err := store.Update(context.Background(), key, time.Time{}, func(state State) (State, bool) {
+		return state, false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.Failures
+}
+
+func TestLimiterReserve(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemory(), testPolicy)
+
+	tests := []struct {
+		retryAfter time.Duration
+		locked     bool
+	}{
+		{0, false},
+		{0, false},
+		{time.Hour, false}, // Allowed, but a failure starts the backoff
+	}
+
+	for i, tt := range tests {
+		wait, result, err := limiter.Reserve(ctx, "user:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || result.Failures != i+1 || result.RetryAfter != tt.retryAfter || result.Locked != tt.locked {
+			t.Errorf("reservation %d = %v, %+v; want retry after %v, locked %v", i+1, wait, result, tt.retryAfter, tt.locked)
+		}
+	}
+
+	// Now the key must wait, and waiting doesn't count another failure
+	for i := 0; i < 2; i++ {
+		wait, _, _ := limiter.Reserve(ctx, "user:1")
+		if wait <= 0 || wait > time.Hour {
+			t.Fatalf("reservation while throttled waited %v, want up to %v", wait, time.Hour)
+		}
+	}
+	if got := failures(t, limiter.store, "user:1"); got != 3 {
+		t.Errorf("failures = %d, want 3", got)
+	}
+
+	// Other keys are unaffected
+	if wait, _, _ := limiter.Reserve(ctx, "user:2"); wait != 0 {
+		t.Errorf("other key waited %v", wait)
+	}
+}
+
+func TestLimiterReserveLocksOut(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	limiter := NewLimiter(store, Policy{FreeAttempts: 1, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: 24 * time.Hour})
+
+	if _, result, _ := limiter.Reserve(ctx, "user:1"); result.Locked {
+		t.Fatalf("free attempt locked the key: %+v", result)
+	}
+	_, result, _ := limiter.Reserve(ctx, "user:1")
+	if !result.Locked || result.RetryAfter != time.Hour || result.LockedTill.IsZero() {
+		t.Errorf("reservation reaching the max delay = %+v, want locked for an hour", result)
+	}
+}
+
+func TestLimiterReleaseAndReset(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	limiter := NewLimiter(store, testPolicy)
+
+	limiter.Reserve(ctx, "ip:a")
+	limiter.Reserve(ctx, "ip:a")
+	if err := limiter.Release(ctx, "ip:a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := failures(t, store, "ip:a"); got != 1 {
+		t.Errorf("failures after release = %d, want the earlier failure kept", got)
+	}
+
+	// Releasing the last failure removes the key entirely
+	limiter.Release(ctx, "ip:a")
+	if _, ok := store.states["ip:a"]; ok {
+		t.Error("key with no failures is still stored")
+	}
+
+	// Releasing a key with no failures doesn't store it
+	if err := limiter.Release(ctx, "ip:b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.states["ip:b"]; ok {
+		t.Error("released unknown key was stored")
+	}
+
+	limiter.Reserve(ctx, "user:1")
+	if err := limiter.Reset(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.states) != 0 {
+		t.Errorf("store still holds %v after reset", store.states)
+	}
+}
+
+// Logins only ever reserve, release and reset, so keys must expire under
+// that traffic alone or unknown names and IPs would pile up forever
+func TestLimiterKeysExpireUnderReserve(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	window := 20 * time.Millisecond
+	limiter := NewLimiter(store, Policy{FreeAttempts: 1000, BaseDelay: time.Second, MaxDelay: time.Second, Window: window})
+
+	for i := 0; i < sweepEvery; i++ {
+		if _, _, err := limiter.Reserve(ctx, "login:user"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.states) != sweepEvery {
+		t.Fatalf("store holds %d keys, want %d", len(store.states), sweepEvery)
+	}
+
+	time.Sleep(2 * window)
+	for i := 0; i < sweepEvery; i++ {
+		limiter.Reserve(ctx, "ip:shared")
+		limiter.Release(ctx, "ip:shared")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.states) != 0 {
+		t.Errorf("store holds %d keys after their window passed, want 0", len(store.states))
+	}
+}
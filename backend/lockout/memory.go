@@ -0,0 +1,67 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many updates the memory store applies between sweeps
+// of forgotten keys
+const sweepEvery = 1000
+
+// Memory is a Store that keeps counters in memory. Counters are lost on
+// restart and aren't shared between server instances.
+type Memory struct {
+	mu     sync.Mutex
+	states map[string]State
+	since  time.Time // Oldest failure time still remembered, for sweeping
+	writes int
+}
+
+// NewMemory creates an in-memory store
+func NewMemory() *Memory {
+	return &Memory{states: make(map[string]State)}
+}
+
+// Reset forgets key
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, key)
+	return nil
+}
+
+// Update applies fn to key's state under the store's lock
+func (m *Memory) Update(ctx context.Context, key string, since time.Time, fn func(State) (State, bool)) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.since = since
+	if m.writes++; m.writes%sweepEvery == 0 {
+		m.sweep()
+	}
+
+	state := m.states[key]
+	if state.LastFailureAt.Before(since) {
+		state = State{}
+	}
+	if updated, ok := fn(state); ok {
+		state = updated
+	}
+	if state.Failures > 0 {
+		m.states[key] = state
+	} else {
+		delete(m.states, key)
+	}
+	return state, nil
+}
+
+// sweep drops keys whose failures have been forgotten
+func (m *Memory) sweep() {
+	for key, state := range m.states {
+		if state.LastFailureAt.Before(m.since) {
+			delete(m.states, key)
+		}
+	}
+}
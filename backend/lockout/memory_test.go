@@ -0,0 +1,102 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	now := time.Now()
+	set := func(state State) func(State) (State, bool) {
+		return func(State) (State, bool) { return state, true }
+	}
+
+	store.Update(ctx, "user:1", now.Add(-3*time.Hour), set(State{Failures: 1, LastFailureAt: now.Add(-2 * time.Hour)}))
+
+	// Forgotten failures are cleared before fn sees the state, and the key
+	// is dropped even though fn declines to change it
+	var seen State
+	state, err := store.Update(ctx, "user:1", now.Add(-time.Hour), func(state State) (State, bool) {
+		seen = state
+		return State{Failures: 5, LastFailureAt: now}, false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen.Failures != 0 || state.Failures != 0 {
+		t.Errorf("fn saw %+v and update returned %+v, want both cleared", seen, state)
+	}
+	if _, ok := store.states["user:1"]; ok {
+		t.Error("expired key is still stored")
+	}
+
+	state, _ = store.Update(ctx, "user:1", now.Add(-time.Hour), set(State{Failures: 5, LastFailureAt: now}))
+	if state.Failures != 5 || store.states["user:1"].Failures != 5 {
+		t.Errorf("update returned %+v and stored %+v, want 5 failures", state, store.states["user:1"])
+	}
+
+	// A state with no failures isn't kept
+	store.Update(ctx, "user:1", now.Add(-time.Hour), set(State{LastFailureAt: now}))
+	if _, ok := store.states["user:1"]; ok {
+		t.Error("key with no failures is still stored")
+	}
+
+	store.Update(ctx, "user:2", now.Add(-time.Hour), set(State{Failures: 1, LastFailureAt: now}))
+	store.Reset(ctx, "user:2")
+	if _, ok := store.states["user:2"]; ok {
+		t.Error("reset key is still stored")
+	}
+}
+
+func TestMemoryUpdateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	since := time.Now().Add(-time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update(ctx, "ip:a", since, func(state State) (State, bool) {
+				state.Failures++
+				state.LastFailureAt = time.Now()
+				return state, true
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got := failures(t, store, "ip:a"); got != 100 {
+		t.Errorf("failures = %d, want 100", got)
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	now := time.Now()
+	fail := func(state State) (State, bool) {
+		return State{Failures: state.Failures + 1, LastFailureAt: now}, true
+	}
+
+	store.Update(ctx, "old", now.Add(-3*time.Hour), func(State) (State, bool) {
+		return State{Failures: 1, LastFailureAt: now.Add(-2 * time.Hour)}, true
+	})
+	for i := 1; i < sweepEvery; i++ {
+		store.Update(ctx, "new", now.Add(-time.Hour), fail)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.states["old"]; ok {
+		t.Error("forgotten key wasn't swept")
+	}
+	if _, ok := store.states["new"]; !ok {
+		t.Error("remembered key was swept")
+	}
+}
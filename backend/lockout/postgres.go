@@ -0,0 +1,70 @@
+package lockout
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"koinonia-backend/models"
+)
+
+// Postgres is a Store backed by the login_attempts table, so counters
+// survive restarts and are shared between server instances
+type Postgres struct {
+	db *gorm.DB
+}
+
+// NewPostgres creates a Postgres-backed store
+func NewPostgres(db *gorm.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Update holds a lock on key for the length of a transaction while fn
+// decides its new state, so concurrent updates are applied one at a time.
+// The lock is an advisory one, so keys without failures need no row.
+func (p *Postgres) Update(ctx context.Context, key string, since time.Time, fn func(State) (State, bool)) (State, error) {
+	var state State
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", key).Error; err != nil {
+			return err
+		}
+
+		var attempt models.LoginAttempt
+		if err := tx.Where("attempt_key = ?", key).Limit(1).Find(&attempt).Error; err != nil {
+			return err
+		}
+		state = State{Failures: attempt.Failures, LastFailureAt: attempt.LastFailureAt}
+		if state.LastFailureAt.Before(since) {
+			state = State{}
+		}
+
+		updated, ok := fn(state)
+		if !ok {
+			return nil
+		}
+		state = updated
+		if state.Failures <= 0 {
+			return tx.Where("attempt_key = ?", key).Delete(&models.LoginAttempt{}).Error
+		}
+		return tx.Exec(`INSERT INTO login_attempts (attempt_key, failures, last_failure_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (attempt_key) DO UPDATE SET
+				failures = EXCLUDED.failures,
+				last_failure_at = EXCLUDED.last_failure_at`,
+			key, state.Failures, state.LastFailureAt).Error
+	})
+	if err != nil {
+		return State{}, err
+	}
+
+	// Drop rows nobody has failed from in a while
+	p.db.WithContext(ctx).Where("last_failure_at < ?", since).Delete(&models.LoginAttempt{})
+
+	return state, nil
+}
+
+// Reset forgets key
+func (p *Postgres) Reset(ctx context.Context, key string) error {
+	return p.db.WithContext(ctx).Where("attempt_key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
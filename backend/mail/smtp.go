@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is a Mailer that delivers through an SMTP server. Locally it can
+// point at a catcher such as MailHog (localhost:1025) with no credentials.
+type SMTP struct {
+	addr     string // host:port
+	from     string
+	username string
+	password string
+}
+
+// NewSMTP creates an SMTP mailer; username and password may be empty for
+// servers that don't require authentication
+func NewSMTP(addr, from, username, password string) *SMTP {
+	return &SMTP{addr: addr, from: from, username: username, password: password}
+}
+
+// Send delivers the message. The context is not used because net/smtp
+// has no cancellation support.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(s.from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// The envelope sender must be a bare address, not "Name <address>"
+	sender := s.from
+	if parsed, err := netmail.ParseAddress(s.from); err == nil {
+		sender = parsed.Address
+	}
+
+	return smtp.SendMail(s.addr, auth, sender, []string{msg.To}, []byte(b.String()))
+}
+
+// headerValue strips line breaks so values can't inject extra headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
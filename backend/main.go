@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -13,7 +18,11 @@ import (
 	"gorm.io/gorm"
 
 	"koinonia-backend/handlers"
+	"koinonia-backend/lockout"
+	"koinonia-backend/mail"
 	"koinonia-backend/models"
+	"koinonia-backend/oidc"
+	"koinonia-backend/storage"
 )
 
 func main() {
@@ -24,13 +33,67 @@ func main() {
 	}
 
 	// Auto-migrate database tables
-	err = db.AutoMigrate(&models.User{}, &models.Quest{}, &models.Submission{})
-	if err != nil {
+	if err := migrate(db); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
-	// Initialize handlers with database
-	h := handlers.New(db)
+	// Media storage for uploads
+	store, err := newStorage()
+	if err != nil {
+		log.Fatal("Failed to configure storage:", err)
+	}
+
+	// Keys for signing access tokens
+	keys, err := loadKeys()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+
+	// Failed login counters
+	attempts, err := newLockoutStore(db)
+	if err != nil {
+		log.Fatal("Failed to configure login lockout:", err)
+	}
+
+	// Single sign-on providers
+	providers, err := loadOIDCProviders()
+	if err != nil {
+		log.Fatal("Failed to load OIDC providers:", err)
+	}
+
+	// Initialize handlers with database and configuration
+	cfg := loadConfig()
+	cfg.Storage = store
+	cfg.Keys = keys
+	cfg.OIDCProviders = providers
+	cfg.AccountLockout = lockout.NewLimiter(attempts, lockout.Policy{
+		FreeAttempts: int(getEnvFloat("LOGIN_FREE_ATTEMPTS", 5)),
+		BaseDelay:    time.Second,
+		MaxDelay:     getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		Window:       24 * time.Hour,
+	})
+	cfg.IPLockout = lockout.NewLimiter(attempts, lockout.Policy{
+		FreeAttempts: int(getEnvFloat("LOGIN_IP_FREE_ATTEMPTS", 50)),
+		BaseDelay:    time.Second,
+		MaxDelay:     getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		Window:       24 * time.Hour,
+	})
+	cfg.Mailer = mail.NewSMTP(
+		getEnv("SMTP_ADDR", "localhost:1025"),
+		getEnv("SMTP_FROM", "Koinonia <no-reply@koinonia.app>"),
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+	)
+	h := handlers.New(db, cfg)
+
+	// Run a one-off admin command instead of the server if one was given
+	if len(os.Args) > 1 {
+		runCommand(h, os.Args[1], os.Args[2:])
+		return
+	}
+
+	// Background jobs
+	startScheduler(h)
 
 	// Setup router
 	r := chi.NewRouter()
@@ -51,37 +114,116 @@ func main() {
 	r.Route("/api", func(r chi.Router) {
 		// Auth routes
 		r.Post("/auth/register", h.Register)
+		r.Get("/auth/registration", h.GetRegistrationPolicy)
 		r.Post("/auth/login", h.Login)
+		r.Post("/auth/refresh", h.RefreshToken)
+		r.Post("/auth/forgot-password", h.ForgotPassword)
+		r.Post("/auth/reset-password", h.ResetPassword)
+		r.Post("/auth/verify-email", h.VerifyEmail)
+		r.Post("/auth/2fa/verify", h.VerifyTwoFactorLogin)
+
+		// Single sign-on routes
+		r.Get("/auth/oidc/providers", h.GetOIDCProviders)
+		r.Get("/auth/oidc/{provider}/login", h.OIDCLogin)
+		r.Get("/auth/oidc/{provider}/callback", h.OIDCCallback)
 
 		// Protected routes (require authentication)
 		r.Group(func(r chi.Router) {
 			r.Use(h.AuthMiddleware) // JWT authentication middleware
 
+			// Session routes
+			r.Post("/auth/logout", h.Logout)
+			r.Post("/auth/logout-all", h.LogoutAll)
+			r.Post("/auth/resend-verification", h.ResendVerification)
+
 			// User routes
 			r.Get("/profile", h.GetProfile)
 			r.Put("/profile", h.UpdateProfile)
+			r.Get("/profile/points", h.GetPointsHistory)
+			r.Get("/profile/submissions", h.GetMySubmissions)
+			r.Get("/profile/export", h.ExportProfile)
+			r.Delete("/profile", h.DeleteProfile)
+
+			// Two-factor authentication
+			r.Post("/profile/2fa/setup", h.SetupTwoFactor)
+			r.Post("/profile/2fa/enable", h.EnableTwoFactor)
+			r.Post("/profile/2fa/disable", h.DisableTwoFactor)
+			r.Post("/profile/2fa/recovery-codes", h.RegenerateRecoveryCodes)
 
 			// Quest routes
 			r.Get("/quests", h.GetQuests)
 			r.Get("/quests/{id}", h.GetQuest)
-			r.Post("/quests/{id}/submit", h.SubmitQuest)
+			r.Get("/chains", h.GetChains)
+			r.Get("/chains/{id}", h.GetChain)
+			r.Get("/submissions/{id}", h.GetSubmission)
+			r.Get("/submissions/{id}/history", h.GetSubmissionHistory)
+			r.Put("/submissions/{id}/withdraw", h.WithdrawSubmission)
+
+			// Submitting requires a verified email
+			r.Group(func(r chi.Router) {
+				r.Use(h.VerifiedEmailMiddleware)
+				r.Post("/quests/{id}/submit", h.SubmitQuest)
+				r.Put("/submissions/{id}", h.UpdateSubmission)
+				r.Post("/uploads", h.UploadMedia)
+			})
 
 			// Leaderboard
 			r.Get("/leaderboard", h.GetLeaderboard)
+			r.Get("/leaderboard/me", h.GetMyRank)
 
-			// Admin routes (require admin role)
+			// Staff routes (each requires a permission granted by the user's role)
 			r.Group(func(r chi.Router) {
-				r.Use(h.AdminMiddleware)
+				r.Use(h.RequirePermission(handlers.PermQuestsWrite))
 				r.Post("/quests", h.CreateQuest)
 				r.Put("/quests/{id}", h.UpdateQuest)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(h.RequirePermission(handlers.PermQuestsPublish))
+				r.Put("/quests/{id}/publish", h.PublishQuest)
+				r.Put("/quests/{id}/unpublish", h.UnpublishQuest)
+				r.Put("/quests/{id}/feature", h.FeatureQuest)
+				r.Put("/quests/{id}/unfeature", h.UnfeatureQuest)
 				r.Delete("/quests/{id}", h.DeleteQuest)
+				r.Post("/chains", h.CreateChain)
+				r.Put("/chains/{id}", h.UpdateChain)
+				r.Delete("/chains/{id}", h.DeleteChain)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(h.RequirePermission(handlers.PermSubmissionsReview))
 				r.Get("/submissions", h.GetSubmissions)
 				r.Put("/submissions/{id}/approve", h.ApproveSubmission)
 				r.Put("/submissions/{id}/reject", h.RejectSubmission)
 			})
+			r.Group(func(r chi.Router) {
+				r.Use(h.RequirePermission(handlers.PermUsersManage))
+				r.Get("/admin/roles", h.GetRoles)
+				r.Get("/admin/users", h.GetUsers)
+				r.Get("/admin/users/{id}", h.GetUser)
+				r.Put("/admin/users/{id}/active", h.SetUserActive)
+				r.Put("/admin/users/{id}/role", h.SetUserRole)
+				r.Post("/admin/users/{id}/points", h.AdjustUserPoints)
+				r.Post("/admin/users/{id}/password-reset", h.SendUserPasswordReset)
+				r.Put("/admin/users/{id}/email-verification", h.SetEmailVerification)
+				r.Post("/admin/users/{id}/unlock", h.UnlockUser)
+				r.Get("/admin/invites", h.GetInviteCodes)
+				r.Post("/admin/invites", h.CreateInviteCode)
+				r.Delete("/admin/invites/{id}", h.RevokeInviteCode)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(h.RequirePermission(handlers.PermAuditView))
+				r.Get("/admin/audit", h.GetAuditEvents)
+			})
 		})
 	})
 
+	// Serve uploads from disk when using local storage
+	if local, ok := store.(*storage.Local); ok {
+		r.Handle("/uploads/*", http.StripPrefix("/uploads/", http.FileServer(http.Dir(local.Dir()))))
+	}
+
+	// Public keys for verifying our access tokens
+	r.Get("/.well-known/jwks.json", h.GetJWKS)
+
 	// Health check
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -97,6 +239,110 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, r))
 }
 
+// migrate auto-migrates the database tables and backfills new columns
+func migrate(db *gorm.DB) error {
+	// Accounts from before email verification existed count as verified
+	backfillVerified := db.Migrator().HasTable(&models.User{}) &&
+		!db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	err := db.AutoMigrate(
+		&models.User{},
+		&models.QuestChain{},
+		&models.Quest{},
+		&models.QuestPrerequisite{},
+		&models.Submission{},
+		&models.PointTransaction{},
+		&models.Upload{},
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.RecoveryCode{},
+		&models.LoginAttempt{},
+		&models.LockoutEvent{},
+		&models.AuditEvent{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.InviteCode{},
+		&models.FeaturedQuest{},
+		&models.ChainCompletion{},
+	)
+	if err != nil {
+		return err
+	}
+
+	if backfillVerified {
+		return db.Model(&models.User{}).Where("email_verified_at IS NULL").
+			UpdateColumn("email_verified_at", gorm.Expr("created_at")).Error
+	}
+	return nil
+}
+
+// runCommand runs an admin command from the command line
+func runCommand(h *handlers.Handler, name string, args []string) {
+	switch name {
+	case "reconcile-points":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		apply := fs.Bool("apply", false, "overwrite cached balances with ledger totals")
+		backfill := fs.Bool("backfill", false, "record drift as opening-balance ledger entries")
+		fs.Parse(args)
+
+		if *apply && *backfill {
+			log.Fatal("Use either -apply or -backfill, not both")
+		}
+
+		drifts, err := h.ReconcilePoints(*apply, *backfill)
+		if err != nil {
+			log.Fatal("Failed to reconcile points:", err)
+		}
+		for _, d := range drifts {
+			fmt.Printf("user %d (%s): cached %d, ledger %d, drift %d\n",
+				d.UserID, d.Username, d.CachedTotal, d.LedgerTotal, d.CachedTotal-d.LedgerTotal)
+		}
+		fmt.Printf("%d user(s) with drift\n", len(drifts))
+	case "purge-deleted-users":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		grace := fs.Duration("grace", getEnvDuration("ACCOUNT_PURGE_GRACE", 30*24*time.Hour), "how long after deletion to purge an account")
+		fs.Parse(args)
+
+		purged, err := h.PurgeDeletedUsers(context.Background(), *grace)
+		if err != nil {
+			log.Printf("Purged %d user(s) before failing", purged)
+			log.Fatal("Failed to purge deleted users:", err)
+		}
+		fmt.Printf("%d user(s) purged\n", purged)
+	case "rotate-featured":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		force := fs.Bool("force", false, "replace today's featured quests if already picked")
+		fs.Parse(args)
+
+		picks, err := h.RotateFeaturedQuests(context.Background(), time.Now(), *force)
+		if err != nil {
+			log.Fatal("Failed to rotate featured quests:", err)
+		}
+		for _, p := range picks {
+			fmt.Printf("%s #%d: quest %d\n", p.Date, p.Position+1, p.QuestID)
+		}
+		fmt.Printf("%d quest(s) featured\n", len(picks))
+	default:
+		log.Fatalf("Unknown command %q (available: reconcile-points, purge-deleted-users, rotate-featured)", name)
+	}
+}
+
+// startScheduler runs periodic jobs in the background while the server is
+// up. Featured quests are checked every minute, so each day's picks are
+// made just after midnight in the quest time zone.
+func startScheduler(h *handlers.Handler) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			if _, err := h.RotateFeaturedQuests(context.Background(), time.Now(), false); err != nil {
+				log.Printf("Failed to rotate featured quests: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
 // connectDB establishes connection to PostgreSQL database
 func connectDB() (*gorm.DB, error) {
 	// Database configuration from environment variables
@@ -119,6 +365,110 @@ func connectDB() (*gorm.DB, error) {
 	return db, nil
 }
 
+// loadConfig reads handler settings from environment variables
+func loadConfig() handlers.Config {
+	return handlers.Config{
+		ScriptureApproveThreshold:   getEnvFloat("SCRIPTURE_APPROVE_THRESHOLD", 0.95),
+		SeasonStart:                 getEnvDate("SEASON_START"),
+		SeasonEnd:                   getEnvDate("SEASON_END"),
+		MaxUploadSize:               int64(getEnvFloat("MAX_UPLOAD_MB", 10) * (1 << 20)),
+		MaxImageDimension:           int(getEnvFloat("MAX_IMAGE_DIMENSION", 2048)),
+		AccessTokenTTL:              getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:             getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		AppURL:                      getEnv("APP_URL", "http://localhost:3000"),
+		PasswordResetTTL:            getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetResendInterval: getEnvDuration("PASSWORD_RESET_RESEND_INTERVAL", 2*time.Minute),
+		VerificationTTL:             getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		VerificationResendInterval:  getEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", 2*time.Minute),
+		RequireAdminTwoFactor:       getEnvBool("REQUIRE_ADMIN_2FA", false),
+		RequireInviteCode:           getEnvBool("REQUIRE_INVITE_CODE", false),
+		AllowedEmailDomains:         getEnvDomains("ALLOWED_EMAIL_DOMAINS"),
+		ShowReviewerToUsers:         getEnvBool("SHOW_REVIEWER_TO_USERS", false),
+		Location:                    getEnvLocation("QUEST_TIMEZONE"),
+		FeaturedQuestCount:          int(getEnvFloat("FEATURED_QUEST_COUNT", 3)),
+	}
+}
+
+// loadKeys loads JWT signing keys from JWT_KEYS_FILE, falling back to a
+// single HS256 JWT_SECRET. A development secret is used outside production
+// when neither is set.
+func loadKeys() (*handlers.KeySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return handlers.LoadKeySetFile(path)
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		if getEnv("ENVIRONMENT", "development") == "production" {
+			return nil, fmt.Errorf("JWT_SECRET or JWT_KEYS_FILE must be set in production")
+		}
+		log.Println("JWT_SECRET not set; using an insecure development secret")
+		secret = "koinonia-development-secret"
+	}
+	return handlers.NewHMACKeySet(getEnv("JWT_KEY_ID", "default"), secret)
+}
+
+// loadOIDCProviders loads single sign-on providers from OIDC_PROVIDERS_FILE,
+// a JSON array of provider configs. Providers without a redirect_url use
+// the API's own callback under API_URL.
+func loadOIDCProviders() ([]*oidc.Provider, error) {
+	path := os.Getenv("OIDC_PROVIDERS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	configs, err := oidc.LoadProviders(path)
+	if err != nil {
+		return nil, err
+	}
+
+	apiURL := strings.TrimRight(getEnv("API_URL", "http://localhost:8080"), "/")
+	providers := make([]*oidc.Provider, 0, len(configs))
+	for _, c := range configs {
+		if c.RedirectURL == "" {
+			c.RedirectURL = apiURL + "/api/auth/oidc/" + c.Name + "/callback"
+		}
+		providers = append(providers, oidc.NewProvider(c))
+	}
+	return providers, nil
+}
+
+// newLockoutStore creates the failed-login counter store selected by
+// LOCKOUT_STORE ("postgres" or "memory")
+func newLockoutStore(db *gorm.DB) (lockout.Store, error) {
+	switch store := getEnv("LOCKOUT_STORE", "postgres"); store {
+	case "postgres":
+		return lockout.NewPostgres(db), nil
+	case "memory":
+		return lockout.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown lockout store %q", store)
+	}
+}
+
+// newStorage creates the media store selected by STORAGE_BACKEND ("local" or "s3")
+func newStorage() (storage.Storage, error) {
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		// Local files are served under /uploads (see main)
+		return storage.NewLocal(
+			getEnv("UPLOAD_DIR", "./uploads"),
+			getEnv("UPLOAD_BASE_URL", "http://localhost:8080/uploads"),
+		)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
 // getEnv gets environment variable with fallback
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
@@ -126,3 +476,78 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvFloat gets a numeric environment variable with fallback
+func getEnvFloat(key string, fallback float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
+	}
+	return fallback
+}
+
+// getEnvBool gets a boolean environment variable (e.g. "true") with fallback
+func getEnvBool(key string, fallback bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
+	}
+	return fallback
+}
+
+// getEnvLocation gets a time zone name (e.g. "America/Chicago") from an
+// environment variable, or nil for the local time zone
+func getEnvLocation(key string) *time.Location {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q", key, value)
+		return nil
+	}
+	return loc
+}
+
+// getEnvDomains gets a comma-separated list of email domains from an
+// environment variable, lower-cased and without any leading "@"
+func getEnvDomains(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "@")
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvDate gets an optional YYYY-MM-DD date environment variable
+func getEnvDate(key string) *time.Time {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q", key, value)
+		return nil
+	}
+	return &parsed
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "15m") with fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("Ignoring invalid %s=%q", key, value)
+	}
+	return fallback
+}
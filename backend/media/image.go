@@ -0,0 +1,123 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	// Register decoders for the formats accepted by uploads
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrImageTooLarge is returned for images with more pixels than allowed
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// Variant is a resized copy of an image, such as a thumbnail
+type Variant struct {
+	Name         string // Appended to the storage key, e.g. "thumb"
+	MaxDimension int    // Longest edge in pixels
+}
+
+// Options controls how uploaded images are processed
+type Options struct {
+	MaxDimension int       // Longest edge of the re-encoded original in pixels
+	MaxPixels    int       // Largest width*height accepted before decoding
+	Variants     []Variant // Resized copies to generate
+}
+
+// Image is an encoded image ready to store
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+}
+
+// Result is a processed upload: the re-encoded original and its variants
+type Result struct {
+	Original Image
+	Variants map[string]Image
+}
+
+// ProcessImage decodes an uploaded image, applies its EXIF orientation and
+// re-encodes it within the bounded dimension, which strips all metadata
+// such as GPS location. PNGs stay PNG; every other format becomes JPEG, and
+// animated GIFs are flattened to their first frame.
+func ProcessImage(data []byte, opts Options) (*Result, error) {
+	// Check dimensions before decoding to avoid decompression bombs
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxPixels > 0 && cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	encode := encodeJPEG
+	if format == "png" {
+		encode = encodePNG
+	}
+
+	original, err := encode(fit(img, opts.MaxDimension))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Original: original, Variants: make(map[string]Image, len(opts.Variants))}
+	for _, variant := range opts.Variants {
+		encoded, err := encode(fit(img, variant.MaxDimension))
+		if err != nil {
+			return nil, err
+		}
+		result.Variants[variant.Name] = encoded
+	}
+
+	return result, nil
+}
+
+// fit scales img down so its longest edge is at most maxDimension
+func fit(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (w <= maxDimension && h <= maxDimension) {
+		return img
+	}
+
+	if w >= h {
+		w, h = maxDimension, max(1, h*maxDimension/w)
+	} else {
+		w, h = max(1, w*maxDimension/h), maxDimension
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) (Image, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return Image{}, err
+	}
+	return Image{Data: buf.Bytes(), ContentType: "image/jpeg", Ext: ".jpg"}, nil
+}
+
+func encodePNG(img image.Image) (Image, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return Image{}, err
+	}
+	return Image{Data: buf.Bytes(), ContentType: "image/png", Ext: ".png"}, nil
+}
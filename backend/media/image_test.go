@@ -0,0 +1,195 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns an image of the given size encoded in format
+func testImage(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation inserts an Exif block with the given orientation after
+// the JPEG start-of-image marker
+func withOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08") // Big-endian, first IFD at 8
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // Orientation
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)      // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // Padding and next IFD offset
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+// decodedSize returns the dimensions of an encoded image
+func decodedSize(t *testing.T, img Image) (int, int) {
+	t.Helper()
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Width, cfg.Height
+}
+
+func TestProcessImageSizing(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		maxDimension int
+		wantW, wantH int
+	}{
+		{"landscape scaled", 400, 200, 100, 100, 50},
+		{"portrait scaled", 200, 400, 100, 50, 100},
+		{"square scaled", 300, 300, 100, 100, 100},
+		{"already small", 80, 60, 100, 80, 60},
+		{"exactly the limit", 100, 40, 100, 100, 40},
+		{"no limit", 400, 200, 0, 400, 200},
+		{"thin strip keeps a pixel", 1000, 2, 100, 100, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ProcessImage(testImage(t, "png", tt.w, tt.h), Options{MaxDimension: tt.maxDimension})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h := decodedSize(t, result.Original); w != tt.wantW || h != tt.wantH {
+				t.Errorf("size = %dx%d, want %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestProcessImageVariants(t *testing.T) {
+	opts := Options{
+		MaxDimension: 200,
+		Variants: []Variant{
+			{Name: "thumb", MaxDimension: 40},
+			{Name: "preview", MaxDimension: 100},
+		},
+	}
+	result, err := ProcessImage(testImage(t, "jpeg", 400, 300), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]int{"thumb": {40, 30}, "preview": {100, 75}}
+	if len(result.Variants) != len(want) {
+		t.Fatalf("got %d variants, want %d", len(result.Variants), len(want))
+	}
+	for name, size := range want {
+		if w, h := decodedSize(t, result.Variants[name]); w != size[0] || h != size[1] {
+			t.Errorf("%s = %dx%d, want %dx%d", name, w, h, size[0], size[1])
+		}
+	}
+	if w, h := decodedSize(t, result.Original); w != 200 || h != 150 {
+		t.Errorf("original = %dx%d, want 200x150", w, h)
+	}
+}
+
+func TestProcessImageFormats(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		ext         string
+	}{
+		{"png", "image/png", ".png"},
+		{"jpeg", "image/jpeg", ".jpg"},
+		{"gif", "image/jpeg", ".jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			result, err := ProcessImage(testImage(t, tt.format, 20, 10), Options{Variants: []Variant{{Name: "thumb", MaxDimension: 5}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, img := range []Image{result.Original, result.Variants["thumb"]} {
+				if img.ContentType != tt.contentType || img.Ext != tt.ext {
+					t.Errorf("encoded as %s (%s), want %s (%s)", img.ContentType, img.Ext, tt.contentType, tt.ext)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessImageOrientation(t *testing.T) {
+	tests := []struct {
+		orientation  uint16
+		wantW, wantH int
+	}{
+		{1, 40, 20},
+		{3, 40, 20},
+		{6, 20, 40},
+		{8, 20, 40},
+	}
+
+	for _, tt := range tests {
+		data := withOrientation(testImage(t, "jpeg", 40, 20), tt.orientation)
+		if got := exifOrientation(data); got != int(tt.orientation) {
+			t.Errorf("exifOrientation = %d, want %d", got, tt.orientation)
+		}
+
+		result, err := ProcessImage(data, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := decodedSize(t, result.Original); w != tt.wantW || h != tt.wantH {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tt.orientation, w, h, tt.wantW, tt.wantH)
+		}
+		if bytes.Contains(result.Original.Data, []byte("Exif")) {
+			t.Errorf("orientation %d: metadata wasn't stripped", tt.orientation)
+		}
+	}
+}
+
+func TestProcessImageRejects(t *testing.T) {
+	_, err := ProcessImage(testImage(t, "png", 100, 100), Options{MaxPixels: 9999})
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrImageTooLarge)
+	}
+	if _, err := ProcessImage(testImage(t, "png", 100, 100), Options{MaxPixels: 10000}); err != nil {
+		t.Errorf("image at the pixel limit was rejected: %v", err)
+	}
+	if _, err := ProcessImage([]byte("not an image"), Options{}); err == nil {
+		t.Error("expected an error for data that isn't an image")
+	}
+}
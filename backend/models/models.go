@@ -21,24 +21,189 @@ type User struct {
 	LastName    string `json:"last_name"`
 	Avatar      string `json:"avatar"`       // URL to profile picture
 	Bio         string `json:"bio"`          // Short biography
-	TotalPoints int    `json:"total_points"` // Accumulated points from quests
+	TotalPoints int    `json:"total_points"` // Cached sum of the user's point transactions
 
 	// User role and status
-	Role      string `json:"role" gorm:"default:user"`        // "user" or "admin"
-	IsActive  bool   `json:"is_active" gorm:"default:true"`   // Account status
-	LastLogin *time.Time `json:"last_login"`                  // Track last login
+	Role      string     `json:"role" gorm:"default:user"`      // One of the Role constants
+	IsActive  bool       `json:"is_active" gorm:"default:true"` // Account status
+	LastLogin *time.Time `json:"last_login"`                    // Track last login
+
+	// Email verification
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"` // Nil until the user follows the emailed link
+	VerificationSentAt *time.Time `json:"-"`                 // Last verification email, for throttling resends
+
+	// Two-factor authentication
+	TwoFactorSecret    string     `json:"-"`                     // TOTP secret; set during setup, before it is enabled
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"` // Nil unless logins require a TOTP code
+	TwoFactorLastStep  int64      `json:"-"`                     // Last accepted TOTP time step, so codes can't be replayed
+
+	// Registration
+	InviteCodeID *uint `json:"invite_code_id,omitempty"` // Invite code the user registered with, if any
+
+	// Account deletion
+	PurgedAt *time.Time `json:"-"` // Set once a deleted account's remaining personal data has been removed
 
 	// Relationships
 	Submissions []Submission `json:"submissions,omitempty" gorm:"foreignKey:UserID"`
 }
 
+// Roles a user can have. Each role grants a set of permissions (see
+// handlers.rolePermissions).
+const (
+	RoleUser        = "user"
+	RoleReviewer    = "reviewer"     // Reviews submissions
+	RoleQuestAuthor = "quest_author" // Drafts quests for admins to publish
+	RoleAdmin       = "admin"
+)
+
+// How a session's login was authenticated
+const (
+	LoginMethodPassword  = "password"   // Username or email and password
+	LoginMethodOIDC      = "oidc"       // Single sign-on
+	LoginMethodTwoFactor = "two_factor" // Completed with a two-factor code after either of the above
+)
+
+// Session represents a login on one device. The refresh token is rotated on
+// every use and only its hash is stored.
+type Session struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID            uint       `json:"user_id" gorm:"not null;index"`
+	RefreshTokenHash  string     `json:"-" gorm:"uniqueIndex;not null"` // SHA-256 of the current refresh token
+	PreviousTokenHash string     `json:"-" gorm:"index"`                // Last rotated-out token, to detect reuse
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	LoginMethod       string     `json:"login_method" gorm:"default:password"` // One of the LoginMethod constants
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+}
+
+// PasswordResetToken is a single-use, time-limited token for resetting a
+// password. Only its hash is stored.
+type PasswordResetToken struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"` // SHA-256 of the emailed token
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// user has lost their authenticator. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// LoginAttempt counts recent failed logins for an account or IP address
+type LoginAttempt struct {
+	AttemptKey    string    `json:"attempt_key" gorm:"primarykey"` // e.g. "user:12" or "ip:203.0.113.7"
+	Failures      int       `json:"failures" gorm:"not null"`
+	LastFailureAt time.Time `json:"last_failure_at" gorm:"index"`
+}
+
+// LockoutAction is what happened in a lockout event
+type LockoutAction string
+
+const (
+	LockoutActionLocked   LockoutAction = "locked"   // Too many failed attempts
+	LockoutActionUnlocked LockoutAction = "unlocked" // An admin lifted the lockout
+)
+
+// LockoutEvent records an account or IP address being locked out after
+// repeated failed logins, or unlocked by an admin
+type LockoutEvent struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	AttemptKey  string        `json:"attempt_key" gorm:"not null;index"`
+	Action      LockoutAction `json:"action" gorm:"not null"`
+	UserID      *uint         `json:"user_id" gorm:"index"` // Nil for IP lockouts and unknown usernames
+	IP          string        `json:"ip"`                   // Address of the request that caused the event
+	Failures    int           `json:"failures"`
+	LockedUntil *time.Time    `json:"locked_until"`
+	AdminID     *uint         `json:"admin_id"` // Admin who unlocked the account
+}
+
+// AuditEvent records an administrative action: who did what to which
+// record, with JSON snapshots of the record before and after
+type AuditEvent struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+
+	ActorID    *uint  `json:"actor_id" gorm:"index"`        // Nil for actions taken by the system
+	Action     string `json:"action" gorm:"not null;index"` // e.g. "user.deactivate"
+	TargetType string `json:"target_type" gorm:"not null;index:idx_audit_target"`
+	TargetID   uint   `json:"target_id" gorm:"index:idx_audit_target"`
+	Before     string `json:"before,omitempty" gorm:"type:text"` // JSON; empty when the record was created
+	After      string `json:"after,omitempty" gorm:"type:text"`  // JSON; empty when the record was deleted
+	IP         string `json:"ip"`
+
+	// Relationships
+	Actor *User `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+}
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's stable subject ID
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   uint   `json:"user_id" gorm:"not null;index"`
+	Provider string `json:"provider" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject  string `json:"-" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email    string `json:"email"` // Email the provider reported at last login
+}
+
+// OIDCLoginState is a pending single sign-on login, created when the user is
+// sent to the provider and consumed when they return. Only the state's hash
+// is stored.
+type OIDCLoginState struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	StateHash    string    `json:"-" gorm:"uniqueIndex;not null"`
+	Provider     string    `json:"provider" gorm:"not null"`
+	Nonce        string    `json:"-" gorm:"not null"`
+	CodeVerifier string    `json:"-" gorm:"not null"` // PKCE verifier sent with the code exchange
+	InviteCode   string    `json:"-"`                 // Invite code to register with if the login creates an account
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+}
+
+// InviteCode admits new users while registration is invite-only. Codes can
+// be limited in uses and time, and give a role to the users who use them.
+type InviteCode struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Code        string     `json:"code" gorm:"uniqueIndex;not null"` // Upper case, e.g. "K7QM-2XPD"
+	Note        string     `json:"note"`                             // Who or what the code is for
+	Role        string     `json:"role" gorm:"default:user"`         // Role given to users who register with it
+	MaxUses     int        `json:"max_uses"`                         // 0 = unlimited uses
+	Uses        int        `json:"uses" gorm:"not null;default:0"`
+	ExpiresAt   *time.Time `json:"expires_at"` // Nil never expires
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedByID *uint      `json:"created_by_id"` // Admin who created it
+}
+
 // QuestType represents different types of quests
 type QuestType string
 
 const (
-	QuestTypeScripture   QuestType = "scripture"   // Scripture memory quests
-	QuestTypeSideQuest   QuestType = "side_quest"  // Photo-based campus challenges
-	QuestTypeTrivia      QuestType = "trivia"      // Bible trivia questions
+	QuestTypeScripture     QuestType = "scripture"     // Scripture memory quests
+	QuestTypeSideQuest     QuestType = "side_quest"    // Photo-based campus challenges
+	QuestTypeTrivia        QuestType = "trivia"        // Bible trivia questions
 	QuestTypeEncouragement QuestType = "encouragement" // Encouraging others
 )
 
@@ -57,29 +222,122 @@ type Quest struct {
 	Difficulty  string    `json:"difficulty"`             // "easy", "medium", "hard"
 
 	// Quest content (varies by type)
-	ScriptureReference string `json:"scripture_reference,omitempty"` // For scripture quests
-	ScriptureText      string `json:"scripture_text,omitempty" gorm:"type:text"` // The verse to memorize
+	ScriptureReference string `json:"scripture_reference,omitempty"`              // For scripture quests
+	ScriptureText      string `json:"scripture_text,omitempty" gorm:"type:text"`  // The verse to memorize
 	TriviaQuestion     string `json:"trivia_question,omitempty" gorm:"type:text"` // For trivia quests
 	TriviaOptions      string `json:"trivia_options,omitempty" gorm:"type:text"`  // JSON array of options
-	CorrectAnswer      string `json:"-"`                            // Hidden from JSON responses
-	
+	CorrectAnswer      string `json:"-"`                                          // Hidden from JSON responses
+
 	// Quest status and metadata
-	IsActive    bool      `json:"is_active" gorm:"default:true"`
-	StartDate   *time.Time `json:"start_date"`   // When quest becomes available
-	EndDate     *time.Time `json:"end_date"`     // When quest expires
-	MaxSubmissions int    `json:"max_submissions"` // 0 = unlimited submissions
+	IsActive       bool       `json:"is_active" gorm:"default:true"`
+	StartDate      *time.Time `json:"start_date"`      // When quest becomes available
+	EndDate        *time.Time `json:"end_date"`        // When quest expires
+	MaxSubmissions int        `json:"max_submissions"` // 0 = unlimited submissions; per occurrence for recurring quests
+	MaxRetries     int        `json:"max_retries"`     // Attempts allowed after rejections; 0 = unlimited, per occurrence for recurring quests
+
+	// Recurrence. StartDate anchors the schedule (CreatedAt when unset) and
+	// EndDate ends it.
+	Recurrence        string `json:"recurrence,omitempty"`         // RRULE subset, e.g. "FREQ=WEEKLY;BYDAY=MO,TH"; empty for one-off quests
+	OccurrenceMinutes int    `json:"occurrence_minutes,omitempty"` // Length of each occurrence; 0 lasts until the next one starts
+	FeaturePool       bool   `json:"feature_pool"`                 // Eligible for the daily featured rotation
+
+	// Chains
+	ChainID       *uint `json:"chain_id" gorm:"index"` // Chain the quest belongs to, if any
+	ChainPosition int   `json:"chain_position"`        // Order within the chain, lowest first
+
+	// Filled in on responses
+	Occurrence      *QuestOccurrence `json:"occurrence,omitempty" gorm:"-"` // Current run, for recurring quests and quests with an end date
+	Featured        bool             `json:"featured" gorm:"-"`             // Featured today
+	PrerequisiteIDs []uint           `json:"prerequisite_ids" gorm:"-"`     // Quests that must be approved first; listings include the previous quest of an ordered chain
+	Locked          bool             `json:"locked" gorm:"-"`               // The user hasn't completed every prerequisite
+	Progress        *QuestProgress   `json:"progress,omitempty" gorm:"-"`   // The user's submissions, for the current occurrence of recurring quests
 
 	// Relationships
 	Submissions []Submission `json:"submissions,omitempty" gorm:"foreignKey:QuestID"`
 }
 
+// QuestPrerequisite makes a quest unlock only once the user has an approved
+// submission for another quest
+type QuestPrerequisite struct {
+	QuestID        uint `json:"quest_id" gorm:"primarykey;autoIncrement:false"`
+	PrerequisiteID uint `json:"prerequisite_id" gorm:"primarykey;autoIncrement:false;index"`
+}
+
+// QuestChain groups quests into a journey. Completing every quest in the
+// chain earns its bonus; in an ordered chain each quest also unlocks only
+// after the one before it.
+type QuestChain struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	Title       string `json:"title" gorm:"not null"`
+	Description string `json:"description" gorm:"type:text"`
+	BonusPoints int    `json:"bonus_points"` // Awarded once for completing every quest
+	Ordered     bool   `json:"ordered"`      // Quests must be completed in chain_position order
+
+	// Relationships
+	Quests []Quest `json:"quests,omitempty" gorm:"foreignKey:ChainID"`
+}
+
+// ChainCompletion records that a user completed a chain and got its bonus
+type ChainCompletion struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"` // When the last quest was approved
+
+	UserID      uint `json:"user_id" gorm:"not null;uniqueIndex:idx_chain_completion"`
+	ChainID     uint `json:"chain_id" gorm:"not null;uniqueIndex:idx_chain_completion"`
+	BonusPoints int  `json:"bonus_points"` // Bonus awarded, as the chain stood then
+}
+
+// QuestOccurrence is the current run of a quest
+type QuestOccurrence struct {
+	Index            int       `json:"index"` // 0 for the first occurrence
+	StartsAt         time.Time `json:"starts_at"`
+	EndsAt           time.Time `json:"ends_at"`
+	RemainingSeconds int64     `json:"remaining_seconds"` // Time left to submit
+}
+
+// QuestStatus is where a user stands on a quest
+type QuestStatus string
+
+const (
+	QuestStatusNotStarted QuestStatus = "not_started" // No submissions yet
+	QuestStatusPending    QuestStatus = "pending"     // A submission awaits review
+	QuestStatusApproved   QuestStatus = "approved"    // A submission was approved
+	QuestStatusRejected   QuestStatus = "rejected"    // The latest submission was rejected
+)
+
+// QuestProgress is the requesting user's state on a quest
+type QuestProgress struct {
+	Status            QuestStatus `json:"status"`
+	SubmissionID      *uint       `json:"submission_id"`         // Latest submission, if any
+	SubmittedAt       *time.Time  `json:"submitted_at"`          // When the latest submission was made
+	AdminNotes        string      `json:"admin_notes,omitempty"` // Reviewer feedback on the latest submission
+	RemainingAttempts *int        `json:"remaining_attempts"`    // Submissions left; null when unlimited
+	RemainingRetries  *int        `json:"remaining_retries"`     // Retries left after rejections; null when unlimited
+}
+
+// FeaturedQuest is a quest featured on a given day. The scheduler picks each
+// day's quests from the feature pool, least recently featured first.
+type FeaturedQuest struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Date     string `json:"date" gorm:"not null;uniqueIndex:idx_featured_date_position;uniqueIndex:idx_featured_date_quest"` // YYYY-MM-DD in the quest time zone
+	Position int    `json:"position" gorm:"not null;uniqueIndex:idx_featured_date_position"`
+	QuestID  uint   `json:"quest_id" gorm:"not null;uniqueIndex:idx_featured_date_quest;index"`
+}
+
 // SubmissionStatus represents the status of a quest submission
 type SubmissionStatus string
 
 const (
-	SubmissionStatusPending  SubmissionStatus = "pending"
-	SubmissionStatusApproved SubmissionStatus = "approved"
-	SubmissionStatusRejected SubmissionStatus = "rejected"
+	SubmissionStatusPending   SubmissionStatus = "pending"
+	SubmissionStatusApproved  SubmissionStatus = "approved"
+	SubmissionStatusRejected  SubmissionStatus = "rejected"
+	SubmissionStatusWithdrawn SubmissionStatus = "withdrawn" // Taken back by the user before review
 )
 
 // Submission represents a user's submission for a quest
@@ -90,20 +348,34 @@ type Submission struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Foreign keys
-	UserID  uint `json:"user_id" gorm:"not null"`
-	QuestID uint `json:"quest_id" gorm:"not null"`
+	UserID  uint `json:"user_id" gorm:"not null;index:idx_submission_user_quest"`
+	QuestID uint `json:"quest_id" gorm:"not null;index:idx_submission_user_quest"`
 
 	// Submission content
-	Content     string `json:"content" gorm:"type:text"`      // Text response/answer
-	MediaURL    string `json:"media_url"`                     // URL to uploaded photo/video
-	MediaType   string `json:"media_type"`                    // "image", "video", "audio"
-	
+	Content   string `json:"content" gorm:"type:text"` // Text response/answer
+	MediaURL  string `json:"media_url"`                // URL to uploaded photo/video
+	MediaType string `json:"media_type"`               // "image", "video", "audio"
+
+	// Start of the recurring quest occurrence the submission counts toward
+	OccurrenceStart *time.Time `json:"occurrence_start,omitempty" gorm:"index"`
+
+	// Resubmissions link to the rejected attempt they retry. Earlier attempts
+	// keep their own review, so the chain is the review history.
+	PreviousSubmissionID *uint `json:"previous_submission_id" gorm:"index"`
+	Attempt              int   `json:"attempt" gorm:"not null;default:1"` // 1 for a first attempt
+
+	// Resized copies of image media, from the upload
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	PreviewURL   string `json:"preview_url,omitempty"`
+
 	// Submission metadata
-	Status       SubmissionStatus `json:"status" gorm:"default:pending"`
-	PointsAwarded int             `json:"points_awarded"`              // Points given (may differ from quest points)
-	AdminNotes   string          `json:"admin_notes" gorm:"type:text"` // Admin feedback
-	ReviewedAt   *time.Time      `json:"reviewed_at"`                  // When admin reviewed
-	ReviewedByID *uint           `json:"reviewed_by_id"`               // Admin who reviewed
+	Status        SubmissionStatus `json:"status" gorm:"default:pending"`
+	PointsAwarded int              `json:"points_awarded"`                          // Points given (may differ from quest points)
+	AdminNotes    string           `json:"admin_notes" gorm:"type:text"`            // Admin feedback
+	ReviewedAt    *time.Time       `json:"reviewed_at"`                             // When admin reviewed
+	ReviewedByID  *uint            `json:"reviewed_by_id"`                          // Admin who reviewed
+	Accuracy      *float64         `json:"accuracy,omitempty"`                      // Auto-grading score (0.0 - 1.0)
+	GradingDiff   string           `json:"grading_diff,omitempty" gorm:"type:text"` // JSON word-level diff from auto-grading
 
 	// Relationships
 	User       User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
@@ -111,14 +383,60 @@ type Submission struct {
 	ReviewedBy *User `json:"reviewed_by,omitempty" gorm:"foreignKey:ReviewedByID"`
 }
 
+// Upload represents a media file a user has uploaded for use in submissions
+type Upload struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID      uint   `json:"user_id" gorm:"not null;index"`
+	Key         string `json:"-" gorm:"uniqueIndex;not null"`   // Storage object key
+	URL         string `json:"url" gorm:"uniqueIndex;not null"` // Public URL given to SubmitQuest
+	ContentType string `json:"content_type"`
+	MediaType   string `json:"media_type"` // "image", "video", "audio"
+	Size        int64  `json:"size"`
+	Filename    string `json:"filename"` // Original client file name
+
+	// Resized variants, set for images only
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	PreviewURL   string `json:"preview_url,omitempty"`
+}
+
+// PointReason describes why a user's point balance changed
+type PointReason string
+
+const (
+	PointReasonSubmissionApproved PointReason = "submission_approved" // Points for an approved submission
+	PointReasonAdminAdjustment    PointReason = "admin_adjustment"    // Manual change by an admin
+	PointReasonOpeningBalance     PointReason = "opening_balance"     // Balance carried over from before the ledger existed
+	PointReasonChainBonus         PointReason = "chain_bonus"         // Bonus for completing every quest in a chain
+)
+
+// PointTransaction is an append-only ledger entry recording a change to a
+// user's points. User.TotalPoints is a cache of the sum of these entries.
+type PointTransaction struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+
+	UserID       uint        `json:"user_id" gorm:"not null;index"`
+	Amount       int         `json:"amount" gorm:"not null"` // May be negative
+	Reason       PointReason `json:"reason" gorm:"not null"`
+	Note         string      `json:"note,omitempty" gorm:"type:text"`
+	SubmissionID *uint       `json:"submission_id,omitempty" gorm:"index"` // Source submission, if any
+	AdminID      *uint       `json:"admin_id,omitempty"`                   // Admin who made the change, if any
+	ChainID      *uint       `json:"chain_id,omitempty"`                   // Completed chain, for chain bonuses
+
+	// Relationships
+	Submission *Submission `json:"submission,omitempty" gorm:"foreignKey:SubmissionID"`
+}
+
 // LeaderboardEntry represents a user's position on the leaderboard
 type LeaderboardEntry struct {
-	Rank        int    `json:"rank"`
-	UserID      uint   `json:"user_id"`
-	Username    string `json:"username"`
-	FirstName   string `json:"first_name"`
-	LastName    string `json:"last_name"`
-	Avatar      string `json:"avatar"`
-	TotalPoints int    `json:"total_points"`
-	QuestsCompleted int `json:"quests_completed"`
+	Rank            int    `json:"rank"`
+	UserID          uint   `json:"user_id"`
+	Username        string `json:"username"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	Avatar          string `json:"avatar"`
+	TotalPoints     int    `json:"total_points"`
+	QuestsCompleted int    `json:"quests_completed"`
 }
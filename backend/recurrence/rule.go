@@ -0,0 +1,190 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is how often a rule repeats
+type Frequency string
+
+const (
+	Daily  Frequency = "DAILY"
+	Weekly Frequency = "WEEKLY"
+)
+
+// untilLayout is the UNTIL format written by String
+const untilLayout = "20060102T150405Z"
+
+// weekdayCodes are the RRULE names of the days of the week
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Rule is a subset of an iCalendar RRULE: daily or weekly repetition with
+// an interval, weekdays, a time of day and an optional count or end. Every
+// occurrence starts at the same local time of day.
+type Rule struct {
+	Freq     Frequency
+	Interval int            // Repeat every Interval days or weeks; at least 1
+	Weekdays []time.Weekday // Weekly only; empty means the anchor's weekday
+	Hour     int            // Time of day occurrences start, or -1 for the anchor's
+	Minute   int            // Minute occurrences start, or -1 for the anchor's
+	Count    int            // Number of occurrences; 0 = unlimited
+	Until    *time.Time     // No occurrence starts after Until
+}
+
+// Parse reads a rule. It accepts "daily", "weekly" or RRULE syntax such as
+// "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;BYHOUR=8;BYMINUTE=0;COUNT=10".
+// Supported parts are FREQ (DAILY or WEEKLY), INTERVAL, BYDAY, BYHOUR,
+// BYMINUTE, COUNT and UNTIL.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1, Hour: -1, Minute: -1}
+
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	switch s {
+	case "":
+		return Rule{}, fmt.Errorf("empty recurrence rule")
+	case "DAILY":
+		rule.Freq = Daily
+		return rule, nil
+	case "WEEKLY":
+		rule.Freq = Weekly
+		return rule, nil
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Freq = Frequency(value)
+			if rule.Freq != Daily && rule.Freq != Weekly {
+				return Rule{}, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = parseRange(value, 1, 366)
+		case "BYHOUR":
+			rule.Hour, err = parseRange(value, 0, 23)
+		case "BYMINUTE":
+			rule.Minute, err = parseRange(value, 0, 59)
+		case "COUNT":
+			rule.Count, err = parseRange(value, 1, 100000)
+		case "UNTIL":
+			until, parseErr := parseUntil(value)
+			rule.Until, err = &until, parseErr
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, ok := weekdayCodes[code]
+				if !ok {
+					return Rule{}, fmt.Errorf("invalid weekday %q", code)
+				}
+				rule.Weekdays = append(rule.Weekdays, day)
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported rule part %q", name)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if rule.Freq == "" {
+		return Rule{}, fmt.Errorf("FREQ is required")
+	}
+	if rule.Freq == Daily && len(rule.Weekdays) > 0 {
+		return Rule{}, fmt.Errorf("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return Rule{}, fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	rule.Weekdays = uniqueWeekdays(rule.Weekdays)
+	return rule, nil
+}
+
+// String formats the rule in RRULE syntax, in a canonical order
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.Weekdays) > 0 {
+		codes := make([]string, len(r.Weekdays))
+		for i, day := range r.Weekdays {
+			for code, d := range weekdayCodes {
+				if d == day {
+					codes[i] = code
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Hour >= 0 {
+		parts = append(parts, "BYHOUR="+strconv.Itoa(r.Hour))
+	}
+	if r.Minute >= 0 {
+		parts = append(parts, "BYMINUTE="+strconv.Itoa(r.Minute))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// parseRange parses an integer between min and max inclusive
+func parseRange(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// parseUntil parses an UNTIL date (YYYYMMDD, the end of that day in UTC)
+// or UTC date-time (YYYYMMDDTHHMMSSZ)
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse(untilLayout, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not YYYYMMDD or YYYYMMDDTHHMMSSZ", value)
+	}
+	return t.Add(24*time.Hour - time.Second), nil
+}
+
+// uniqueWeekdays sorts weekdays Monday first and drops repeats
+func uniqueWeekdays(days []time.Weekday) []time.Weekday {
+	sort.Slice(days, func(i, j int) bool { return weekdayOrder(days[i]) < weekdayOrder(days[j]) })
+	var unique []time.Weekday
+	for i, day := range days {
+		if i == 0 || day != days[i-1] {
+			unique = append(unique, day)
+		}
+	}
+	return unique
+}
+
+// weekdayOrder numbers weekdays from Monday (0) to Sunday (6)
+func weekdayOrder(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
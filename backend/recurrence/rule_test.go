@@ -0,0 +1,102 @@
+package recurrence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	until := time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC)
+	untilTime := time.Date(2024, 3, 31, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		in   string
+		want Rule
+	}{
+		{"daily", Rule{Freq: Daily, Interval: 1, Hour: -1, Minute: -1}},
+		{" Weekly ", Rule{Freq: Weekly, Interval: 1, Hour: -1, Minute: -1}},
+		{"FREQ=DAILY", Rule{Freq: Daily, Interval: 1, Hour: -1, Minute: -1}},
+		{"RRULE:FREQ=DAILY;INTERVAL=3", Rule{Freq: Daily, Interval: 3, Hour: -1, Minute: -1}},
+		{"freq=weekly;byday=fr,mo,we,mo;byhour=8;byminute=30", Rule{
+			Freq: Weekly, Interval: 1, Hour: 8, Minute: 30,
+			Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday},
+		}},
+		{"FREQ=WEEKLY;BYDAY=SU,SA", Rule{
+			Freq: Weekly, Interval: 1, Hour: -1, Minute: -1,
+			Weekdays: []time.Weekday{time.Saturday, time.Sunday},
+		}},
+		{"FREQ=DAILY;COUNT=10", Rule{Freq: Daily, Interval: 1, Hour: -1, Minute: -1, Count: 10}},
+		{"FREQ=DAILY;UNTIL=20240331", Rule{Freq: Daily, Interval: 1, Hour: -1, Minute: -1, Until: &until}},
+		{"FREQ=DAILY;UNTIL=20240331T183000Z", Rule{Freq: Daily, Interval: 1, Hour: -1, Minute: -1, Until: &untilTime}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"monthly",
+		"FREQ=MONTHLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=x",
+		"FREQ=DAILY;BYHOUR=24",
+		"FREQ=DAILY;BYMINUTE=60",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;UNTIL=2024-03-31",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;COUNT=3;UNTIL=20240331",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;INTERVAL",
+		"FREQ=DAILY;INTERVAL=",
+	}
+
+	for _, in := range tests {
+		if rule, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", in, rule)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"daily", "FREQ=DAILY"},
+		{"FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+		{"BYHOUR=8;FREQ=WEEKLY;BYDAY=WE,MO;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;BYHOUR=8"},
+		{"FREQ=DAILY;BYMINUTE=0;COUNT=5", "FREQ=DAILY;BYMINUTE=0;COUNT=5"},
+		{"FREQ=DAILY;UNTIL=20240331", "FREQ=DAILY;UNTIL=20240331T235959Z"},
+	}
+
+	for _, tt := range tests {
+		rule, err := Parse(tt.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.in, err)
+		}
+		got := rule.String()
+		if got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+
+		// The canonical form parses back to the same rule
+		again, err := Parse(got)
+		if err != nil || !reflect.DeepEqual(again, rule) {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", got, again, err, rule)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package recurrence
+
+import "time"
+
+// Occurrence is one run of a schedule
+type Occurrence struct {
+	Index int // 0 for the first occurrence
+	Start time.Time
+	End   time.Time
+}
+
+// Schedule places a rule's occurrences in time
+type Schedule struct {
+	Rule Rule
+
+	// Anchor is when the schedule begins; no occurrence starts before it.
+	// It also supplies the weekday and time of day the rule leaves out.
+	Anchor time.Time
+
+	// Length is how long each occurrence lasts; 0 lasts until the next
+	// occurrence would start
+	Length time.Duration
+
+	// Location is the time zone days and times of day are reckoned in;
+	// nil means the local time zone
+	Location *time.Location
+}
+
+// At returns the occurrence in progress at t, if any
+func (s Schedule) At(t time.Time) (Occurrence, bool) {
+	t = t.In(s.location())
+	limit := s.period()
+	for i := 0; i <= limit; i++ {
+		day := civilDay(t, -i)
+		index, ok := s.onDay(day, true)
+		if !ok || s.slot(day).After(t) {
+			continue
+		}
+
+		occurrence := s.occurrence(index, s.slot(day))
+		if t.Before(occurrence.End) {
+			return occurrence, true
+		}
+		return Occurrence{}, false
+	}
+	return Occurrence{}, false
+}
+
+// Next returns the first occurrence that starts after t, if any
+func (s Schedule) Next(t time.Time) (Occurrence, bool) {
+	start, index, ok := s.nextStart(t, true)
+	if !ok {
+		return Occurrence{}, false
+	}
+	return s.occurrence(index, start), true
+}
+
+// occurrence builds the occurrence with the given index and start
+func (s Schedule) occurrence(index int, start time.Time) Occurrence {
+	end := start.Add(s.Length)
+	if s.Length <= 0 {
+		// The last occurrence of a limited rule runs as long as the rest
+		next, _, _ := s.nextStart(start, false)
+		end = next
+	}
+	return Occurrence{Index: index, Start: start, End: end}
+}
+
+// nextStart finds the first start after t. Unless bounded, COUNT and UNTIL
+// are ignored.
+func (s Schedule) nextStart(t time.Time, bounded bool) (time.Time, int, bool) {
+	t = t.In(s.location())
+	from := t
+	if anchor := s.anchor(); anchor.After(from) {
+		from = anchor
+	}
+
+	limit := s.period() + 1
+	for i := 0; i <= limit; i++ {
+		day := civilDay(from, i)
+		index, ok := s.onDay(day, bounded)
+		if ok && s.slot(day).After(t) {
+			return s.slot(day), index, true
+		}
+	}
+	return time.Time{}, 0, false
+}
+
+// onDay reports whether an occurrence starts on day, and its index
+func (s Schedule) onDay(day time.Time, bounded bool) (int, bool) {
+	anchor := s.anchor()
+	anchorDay := civilDay(anchor, 0)
+	offset := days(day) - days(anchorDay)
+	if offset < 0 || (offset == 0 && s.slot(day).Before(anchor)) {
+		return 0, false
+	}
+	interval := s.Rule.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	// The anchor's own day is skipped when its start is before the anchor
+	skip := 0
+	if s.slot(anchorDay).Before(anchor) && s.occursOn(anchor.Weekday()) {
+		skip = 1
+	}
+
+	var index int
+	switch s.Rule.Freq {
+	case Weekly:
+		if !s.occursOn(day.Weekday()) {
+			return 0, false
+		}
+		week := (monday(day) - monday(anchorDay)) / 7
+		if week%interval != 0 {
+			return 0, false
+		}
+		index = week/interval*len(s.weekdays()) + s.before(day.Weekday()) - s.before(anchor.Weekday()) - skip
+	default:
+		if offset%interval != 0 {
+			return 0, false
+		}
+		index = offset/interval - skip
+	}
+
+	if bounded {
+		if s.Rule.Count > 0 && index >= s.Rule.Count {
+			return 0, false
+		}
+		if s.Rule.Until != nil && s.slot(day).After(*s.Rule.Until) {
+			return 0, false
+		}
+	}
+	return index, true
+}
+
+// slot returns when an occurrence on day would start
+func (s Schedule) slot(day time.Time) time.Time {
+	anchor := s.anchor()
+	hour, minute, sec, nsec := s.Rule.Hour, s.Rule.Minute, 0, 0
+	if hour < 0 && minute < 0 {
+		sec, nsec = anchor.Second(), anchor.Nanosecond()
+	}
+	if hour < 0 {
+		hour = anchor.Hour()
+	}
+	if minute < 0 {
+		minute = anchor.Minute()
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, sec, nsec, s.location())
+}
+
+// weekdays returns the days a weekly rule occurs on
+func (s Schedule) weekdays() []time.Weekday {
+	if len(s.Rule.Weekdays) > 0 {
+		return s.Rule.Weekdays
+	}
+	return []time.Weekday{s.anchor().Weekday()}
+}
+
+// occursOn reports whether the rule occurs on a weekday
+func (s Schedule) occursOn(day time.Weekday) bool {
+	if s.Rule.Freq != Weekly {
+		return true
+	}
+	for _, d := range s.weekdays() {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// before counts the rule's weekdays earlier in the week than day
+func (s Schedule) before(day time.Weekday) int {
+	count := 0
+	for _, d := range s.weekdays() {
+		if weekdayOrder(d) < weekdayOrder(day) {
+			count++
+		}
+	}
+	return count
+}
+
+// period is the most days between consecutive occurrences
+func (s Schedule) period() int {
+	interval := s.Rule.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	if s.Rule.Freq == Weekly {
+		return 7 * interval
+	}
+	return interval
+}
+
+func (s Schedule) anchor() time.Time {
+	return s.Anchor.In(s.location())
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+// civilDay returns midnight n days after t's date, in t's location
+func civilDay(t time.Time, n int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+n, 0, 0, 0, 0, t.Location())
+}
+
+// days numbers calendar dates consecutively, ignoring time zones and DST
+func days(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// monday returns the day number of the Monday starting t's week
+func monday(t time.Time) int {
+	return days(t) - weekdayOrder(t.Weekday())
+}
@@ -0,0 +1,116 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+// date returns a time in UTC; 2024-01-01 is a Monday
+func date(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func mustParse(t *testing.T, s string) Rule {
+	t.Helper()
+	rule, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		anchor time.Time
+		after  time.Time
+		index  int
+		start  time.Time
+		ok     bool
+	}{
+		{"daily before anchor", "daily", date(1, 1, 9, 0), date(1, 1, 0, 0), 0, date(1, 1, 9, 0), true},
+		{"daily after first", "daily", date(1, 1, 9, 0), date(1, 1, 9, 0), 1, date(1, 2, 9, 0), true},
+		{"daily interval", "FREQ=DAILY;INTERVAL=3", date(1, 1, 9, 0), date(1, 2, 0, 0), 1, date(1, 4, 9, 0), true},
+		{"time before anchor skips its day", "FREQ=DAILY;BYHOUR=8", date(1, 1, 12, 0), date(1, 1, 0, 0), 0, date(1, 2, 8, 0), true},
+		{"weekly anchor weekday", "weekly", date(1, 3, 18, 0), date(1, 4, 0, 0), 1, date(1, 10, 18, 0), true},
+		{"weekly days", "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=8;BYMINUTE=0", date(1, 1, 0, 0), date(1, 1, 8, 0), 1, date(1, 3, 8, 0), true},
+		{"weekly days next week", "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=8;BYMINUTE=0", date(1, 1, 0, 0), date(1, 5, 8, 0), 3, date(1, 8, 8, 0), true},
+		{"weekly anchored midweek", "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=8;BYMINUTE=0", date(1, 3, 0, 0), date(1, 3, 0, 0), 0, date(1, 3, 8, 0), true},
+		{"every other week", "FREQ=WEEKLY;INTERVAL=2", date(1, 1, 9, 0), date(1, 1, 9, 0), 1, date(1, 15, 9, 0), true},
+		{"last counted occurrence", "FREQ=DAILY;COUNT=3", date(1, 1, 9, 0), date(1, 2, 9, 0), 2, date(1, 3, 9, 0), true},
+		{"count exhausted", "FREQ=DAILY;COUNT=3", date(1, 1, 9, 0), date(1, 3, 9, 0), 0, time.Time{}, false},
+		{"until reached", "FREQ=DAILY;UNTIL=20240103", date(1, 1, 9, 0), date(1, 3, 9, 0), 0, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := Schedule{Rule: mustParse(t, tt.rule), Anchor: tt.anchor, Location: time.UTC}
+			got, ok := schedule.Next(tt.after)
+			if ok != tt.ok {
+				t.Fatalf("Next(%v) ok = %v, want %v", tt.after, ok, tt.ok)
+			}
+			if ok && (got.Index != tt.index || !got.Start.Equal(tt.start)) {
+				t.Errorf("Next(%v) = #%d at %v, want #%d at %v", tt.after, got.Index, got.Start, tt.index, tt.start)
+			}
+		})
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		length time.Duration
+		at     time.Time
+		index  int
+		start  time.Time
+		end    time.Time
+		ok     bool
+	}{
+		{"during fixed length", "daily", time.Hour, date(1, 3, 9, 30), 2, date(1, 3, 9, 0), date(1, 3, 10, 0), true},
+		{"after fixed length", "daily", time.Hour, date(1, 3, 11, 0), 0, time.Time{}, time.Time{}, false},
+		{"before anchor", "daily", time.Hour, date(1, 1, 8, 0), 0, time.Time{}, time.Time{}, false},
+		{"runs until next", "daily", 0, date(1, 3, 23, 0), 2, date(1, 3, 9, 0), date(1, 4, 9, 0), true},
+		{"weekly runs until next day", "FREQ=WEEKLY;BYDAY=MO,FR", 0, date(1, 3, 12, 0), 0, date(1, 1, 9, 0), date(1, 5, 9, 0), true},
+		{"last counted occurrence runs as long", "FREQ=DAILY;COUNT=3", 0, date(1, 3, 20, 0), 2, date(1, 3, 9, 0), date(1, 4, 9, 0), true},
+		{"after last counted occurrence", "FREQ=DAILY;COUNT=3", 0, date(1, 4, 10, 0), 0, time.Time{}, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := Schedule{Rule: mustParse(t, tt.rule), Anchor: date(1, 1, 9, 0), Length: tt.length, Location: time.UTC}
+			got, ok := schedule.At(tt.at)
+			if ok != tt.ok {
+				t.Fatalf("At(%v) ok = %v, want %v", tt.at, ok, tt.ok)
+			}
+			if ok && (got.Index != tt.index || !got.Start.Equal(tt.start) || !got.End.Equal(tt.end)) {
+				t.Errorf("At(%v) = #%d %v-%v, want #%d %v-%v", tt.at, got.Index, got.Start, got.End, tt.index, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestScheduleKeepsLocalTimeAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data unavailable:", err)
+	}
+
+	// Clocks go forward on 2024-03-10
+	schedule := Schedule{
+		Rule:     mustParse(t, "FREQ=DAILY;BYHOUR=9;BYMINUTE=0"),
+		Anchor:   time.Date(2024, 3, 9, 0, 0, 0, 0, loc),
+		Location: loc,
+	}
+	first, _ := schedule.Next(schedule.Anchor)
+	second, _ := schedule.Next(first.Start)
+
+	want := time.Date(2024, 3, 10, 9, 0, 0, 0, loc)
+	if !second.Start.Equal(want) || second.Index != 1 {
+		t.Errorf("second occurrence = #%d at %v, want #1 at %v", second.Index, second.Start, want)
+	}
+	if got := second.Start.Sub(first.Start); got != 23*time.Hour {
+		t.Errorf("gap across DST = %v, want 23h", got)
+	}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores uploaded media and exposes it at public URLs
+type Storage interface {
+	// Put stores data under key, replacing any existing object
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+
+	// Get returns the data stored under key
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the object stored under key
+	Delete(ctx context.Context, key string) error
+
+	// URL returns the public URL of the object stored under key
+	URL(key string) string
+}
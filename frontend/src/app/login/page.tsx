@@ -1,12 +1,12 @@
 'use client';
 
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 import { useRouter } from 'next/navigation';
 import Link from 'next/link';
 import { Button } from '@/components/ui/button';
 import { Input } from '@/components/ui/input';
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card';
-import { authAPI } from '@/lib/api';
+import { authAPI, AuthResponse, OIDCProvider } from '@/lib/api';
 import { BookOpen, Eye, EyeOff } from 'lucide-react';
 
 export default function Login() {
@@ -17,22 +17,49 @@ export default function Login() {
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
   const [showPassword, setShowPassword] = useState(false);
+  const [twoFactorToken, setTwoFactorToken] = useState('');
+  const [twoFactorCode, setTwoFactorCode] = useState('');
+  const [providers, setProviders] = useState<OIDCProvider[]>([]);
   const router = useRouter();
 
+  useEffect(() => {
+    // Single sign-on buttons only show when the server has providers configured
+    authAPI.getOIDCProviders().then(setProviders).catch(() => setProviders([]));
+  }, []);
+
+  const completeLogin = (response: AuthResponse) => {
+    // Save token and user data
+    localStorage.setItem('token', response.token);
+    localStorage.setItem('refresh_token', response.refresh_token);
+    localStorage.setItem('user', JSON.stringify(response.user));
+
+    // Redirect to home page
+    router.push('/');
+  };
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setLoading(true);
     setError('');
 
     try {
+      if (twoFactorToken) {
+        // Second step: accept either an authenticator code or a recovery code
+        const code = twoFactorCode.trim();
+        completeLogin(await authAPI.verifyTwoFactor(
+          /^\d{6}$/.test(code)
+            ? { two_factor_token: twoFactorToken, code }
+            : { two_factor_token: twoFactorToken, recovery_code: code }
+        ));
+        return;
+      }
+
       const response = await authAPI.login(formData);
-      
-      // Save token and user data
-      localStorage.setItem('token', response.token);
-      localStorage.setItem('user', JSON.stringify(response.user));
-      
-      // Redirect to home page
-      router.push('/');
+      if ('two_factor_required' in response) {
+        setTwoFactorToken(response.two_factor_token);
+        return;
+      }
+      completeLogin(response);
     } catch (err: unknown) {
       const error = err as { response?: { data?: { error?: string } } };
       setError(error.response?.data?.error || 'Login failed. Please try again.');
@@ -78,52 +105,79 @@ export default function Login() {
                 </div>
               )}
 
-              <div>
-                <label htmlFor="username" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
-                  Username or Email
-                </label>
-                <Input
-                  id="username"
-                  name="username"
-                  type="text"
-                  autoComplete="username"
-                  required
-                  value={formData.username}
-                  onChange={handleChange}
-                  placeholder="Enter your username or email"
-                  className="w-full"
-                />
-              </div>
-
-              <div>
-                <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
-                  Password
-                </label>
-                <div className="relative">
+              {twoFactorToken ? (
+                <div>
+                  <label htmlFor="two_factor_code" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                    Authentication Code
+                  </label>
                   <Input
-                    id="password"
-                    name="password"
-                    type={showPassword ? 'text' : 'password'}
-                    autoComplete="current-password"
+                    id="two_factor_code"
+                    name="two_factor_code"
+                    type="text"
+                    inputMode="numeric"
+                    autoComplete="one-time-code"
                     required
-                    value={formData.password}
-                    onChange={handleChange}
-                    placeholder="Enter your password"
-                    className="w-full pr-10"
+                    value={twoFactorCode}
+                    onChange={(e) => setTwoFactorCode(e.target.value)}
+                    placeholder="6-digit code or a recovery code"
+                    className="w-full"
                   />
-                  <button
-                    type="button"
-                    className="absolute inset-y-0 right-0 pr-3 flex items-center"
-                    onClick={() => setShowPassword(!showPassword)}
-                  >
-                    {showPassword ? (
-                      <EyeOff className="h-5 w-5 text-gray-400" />
-                    ) : (
-                      <Eye className="h-5 w-5 text-gray-400" />
-                    )}
-                  </button>
                 </div>
-              </div>
+              ) : (
+                <>
+                  <div>
+                    <label htmlFor="username" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                      Username or Email
+                    </label>
+                    <Input
+                      id="username"
+                      name="username"
+                      type="text"
+                      autoComplete="username"
+                      required
+                      value={formData.username}
+                      onChange={handleChange}
+                      placeholder="Enter your username or email"
+                      className="w-full"
+                    />
+                  </div>
+
+                  <div>
+                    <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                      Password
+                    </label>
+                    <div className="relative">
+                      <Input
+                        id="password"
+                        name="password"
+                        type={showPassword ? 'text' : 'password'}
+                        autoComplete="current-password"
+                        required
+                        value={formData.password}
+                        onChange={handleChange}
+                        placeholder="Enter your password"
+                        className="w-full pr-10"
+                      />
+                      <button
+                        type="button"
+                        className="absolute inset-y-0 right-0 pr-3 flex items-center"
+                        onClick={() => setShowPassword(!showPassword)}
+                      >
+                        {showPassword ? (
+                          <EyeOff className="h-5 w-5 text-gray-400" />
+                        ) : (
+                          <Eye className="h-5 w-5 text-gray-400" />
+                        )}
+                      </button>
+                    </div>
+                    <div className="mt-2 text-right text-sm">
+                      <Link href="/reset-password" className="text-blue-600 hover:underline">
+                        Forgot your password?
+                      </Link>
+                    </div>
+                  </div>
+                </>
+              )}
 
               <Button
                 type="submit"
@@ -135,12 +189,26 @@ export default function Login() {
                     <div className="animate-spin rounded-full h-4 w-4 border-b-2 border-white mr-2"></div>
                     Signing in...
                   </div>
+                ) : twoFactorToken ? (
+                  'Verify'
                 ) : (
                   'Sign in'
                 )}
               </Button>
             </form>
 
+            {!twoFactorToken && providers.length > 0 && (
+              <div className="mt-6 space-y-3">
+                {providers.map((provider) => (
+                  <a key={provider.name} href={authAPI.oidcLoginURL(provider.name)} className="block">
+                    <Button type="button" variant="outline" className="w-full">
+                      Continue with {provider.display_name}
+                    </Button>
+                  </a>
+                ))}
+              </div>
+            )}
+
             <div className="mt-6">
               <div className="relative">
                 <div className="absolute inset-0 flex items-center">
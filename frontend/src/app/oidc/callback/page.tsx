@@ -0,0 +1,169 @@
+'use client';
+
+import { useEffect, useRef, useState } from 'react';
+import { useRouter } from 'next/navigation';
+import Link from 'next/link';
+import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card';
+import { authAPI, userAPI, AuthResponse } from '@/lib/api';
+import { BookOpen } from 'lucide-react';
+
+// Messages for the error codes the backend puts in the callback fragment
+const errorMessages: Record<string, string> = {
+  provider_error: 'The sign-in provider reported an error. Please try again.',
+  invalid_state: 'This sign-in link has expired or was already used. Please start again.',
+  email_unverified: 'Your provider account has no verified email address.',
+  account_deactivated: 'This account has been deactivated.',
+  login_failed: 'Sign-in failed. Please try again.',
+  invalid_invite: 'That invite code is invalid or has expired.',
+  invite_required: 'An invite code is required to create an account.',
+  email_domain_not_allowed: 'Accounts with this email domain are not allowed.',
+  account_unverified: 'An account with this email already exists. Verify its email or sign in with your password to link it.',
+};
+
+export default function OIDCCallback() {
+  const [error, setError] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [twoFactorToken, setTwoFactorToken] = useState('');
+  const [twoFactorCode, setTwoFactorCode] = useState('');
+  const handled = useRef(false);
+  const router = useRouter();
+
+  useEffect(() => {
+    // The fragment is read once; effects can run twice in development
+    if (handled.current) return;
+    handled.current = true;
+
+    // Tokens arrive in the fragment so they never reach server logs; clear it
+    // from the address bar before doing anything else
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, '', window.location.pathname);
+
+    const code = params.get('error');
+    const token = params.get('token');
+    const refreshToken = params.get('refresh_token');
+    const challenge = params.get('two_factor_token');
+
+    if (code) {
+      setError(errorMessages[code] || errorMessages.login_failed);
+    } else if (challenge) {
+      setTwoFactorToken(challenge);
+    } else if (token && refreshToken) {
+      localStorage.setItem('token', token);
+      localStorage.setItem('refresh_token', refreshToken);
+
+      // The redirect carries only tokens, so load the user separately
+      userAPI.getProfile()
+        .then((user) => {
+          localStorage.setItem('user', JSON.stringify(user));
+          router.push('/');
+        })
+        .catch(() => {
+          localStorage.removeItem('token');
+          localStorage.removeItem('refresh_token');
+          setError(errorMessages.login_failed);
+        });
+    } else {
+      setError(errorMessages.login_failed);
+    }
+  }, [router]);
+
+  const completeLogin = (response: AuthResponse) => {
+    localStorage.setItem('token', response.token);
+    localStorage.setItem('refresh_token', response.refresh_token);
+    localStorage.setItem('user', JSON.stringify(response.user));
+    router.push('/');
+  };
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setLoading(true);
+    setError('');
+
+    try {
+      // Accept either an authenticator code or a recovery code
+      const code = twoFactorCode.trim();
+      completeLogin(await authAPI.verifyTwoFactor(
+        /^\d{6}$/.test(code)
+          ? { two_factor_token: twoFactorToken, code }
+          : { two_factor_token: twoFactorToken, recovery_code: code }
+      ));
+    } catch (err: unknown) {
+      const error = err as { response?: { data?: { error?: string } } };
+      setError(error.response?.data?.error || 'Verification failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 dark:bg-gray-900 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-8">
+        {/* Header */}
+        <div className="text-center">
+          <BookOpen className="mx-auto h-12 w-12 text-blue-600" />
+          <h2 className="mt-6 text-3xl font-bold text-gray-900 dark:text-white">
+            Sign in to Koinonia
+          </h2>
+        </div>
+
+        <Card>
+          <CardHeader>
+            <CardTitle className="text-center">
+              {twoFactorToken ? 'Two-factor authentication' : error ? 'Sign-in failed' : 'Signing you in...'}
+            </CardTitle>
+            {twoFactorToken && (
+              <CardDescription className="text-center">
+                Enter the code from your authenticator app to finish signing in
+              </CardDescription>
+            )}
+          </CardHeader>
+          <CardContent>
+            {error && (
+              <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-md mb-6">
+                {error}
+              </div>
+            )}
+
+            {twoFactorToken ? (
+              <form onSubmit={handleSubmit} className="space-y-6">
+                <div>
+                  <label htmlFor="two_factor_code" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                    Authentication Code
+                  </label>
+                  <Input
+                    id="two_factor_code"
+                    name="two_factor_code"
+                    type="text"
+                    inputMode="numeric"
+                    autoComplete="one-time-code"
+                    required
+                    value={twoFactorCode}
+                    onChange={(e) => setTwoFactorCode(e.target.value)}
+                    placeholder="6-digit code or a recovery code"
+                    className="w-full"
+                  />
+                </div>
+
+                <Button type="submit" disabled={loading} className="w-full">
+                  {loading ? 'Verifying...' : 'Verify'}
+                </Button>
+              </form>
+            ) : error ? (
+              <Link href="/login">
+                <Button variant="outline" className="w-full">
+                  Back to sign in
+                </Button>
+              </Link>
+            ) : (
+              <div className="flex justify-center">
+                <div className="animate-spin rounded-full h-8 w-8 border-b-2 border-blue-600"></div>
+              </div>
+            )}
+          </CardContent>
+        </Card>
+      </div>
+    </div>
+  );
+}
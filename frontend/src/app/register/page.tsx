@@ -1,12 +1,12 @@
 'use client';
 
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 import { useRouter } from 'next/navigation';
 import Link from 'next/link';
 import { Button } from '@/components/ui/button';
 import { Input } from '@/components/ui/input';
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card';
-import { authAPI } from '@/lib/api';
+import { authAPI, RegistrationPolicy } from '@/lib/api';
 import { BookOpen, Eye, EyeOff } from 'lucide-react';
 
 export default function Register() {
@@ -17,13 +17,26 @@ export default function Register() {
     confirmPassword: '',
     first_name: '',
     last_name: '',
+    invite_code: '',
   });
+  const [policy, setPolicy] = useState<RegistrationPolicy | null>(null);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
   const [showPassword, setShowPassword] = useState(false);
   const [showConfirmPassword, setShowConfirmPassword] = useState(false);
   const router = useRouter();
 
+  // Invite links carry the code as ?invite=CODE
+  useEffect(() => {
+    const invite = new URLSearchParams(window.location.search).get('invite');
+    if (invite) {
+      setFormData(prev => ({ ...prev, invite_code: invite }));
+    }
+    authAPI.getRegistrationPolicy().then(setPolicy).catch(() => setPolicy(null));
+  }, []);
+
+  const showInviteCode = !!policy && (policy.invite_required || policy.allowed_email_domains.length > 0);
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setLoading(true);
@@ -45,11 +58,14 @@ export default function Register() {
 
     try {
       // eslint-disable-next-line @typescript-eslint/no-unused-vars
-      const { confirmPassword, ...registrationData } = formData;
-      const response = await authAPI.register(registrationData);
+      const { confirmPassword, invite_code, ...registrationData } = formData;
+      const response = await authAPI.register(
+        invite_code ? { ...registrationData, invite_code } : registrationData
+      );
       
       // Save token and user data
       localStorage.setItem('token', response.token);
+      localStorage.setItem('refresh_token', response.refresh_token);
       localStorage.setItem('user', JSON.stringify(response.user));
       
       // Redirect to home page
@@ -165,6 +181,30 @@ export default function Register() {
                 />
               </div>
 
+              {showInviteCode && policy && (
+                <div>
+                  <label htmlFor="invite_code" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">
+                    Invite Code
+                  </label>
+                  <Input
+                    id="invite_code"
+                    name="invite_code"
+                    type="text"
+                    autoComplete="off"
+                    required={policy.invite_required && policy.allowed_email_domains.length === 0}
+                    value={formData.invite_code}
+                    onChange={handleChange}
+                    placeholder="XXXX-XXXX"
+                  />
+                  {policy.allowed_email_domains.length > 0 && (
+                    <p className="mt-1 text-xs text-gray-600 dark:text-gray-400">
+                      {policy.invite_required ? 'Not needed with' : 'Required unless you use'} an email at{' '}
+                      {policy.allowed_email_domains.map(d => '@' + d).join(', ')}
+                    </p>
+                  )}
+                </div>
+              )}
+
               <div>
                 <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">
                   Password
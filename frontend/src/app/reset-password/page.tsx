@@ -0,0 +1,174 @@
+'use client';
+
+import { useEffect, useState } from 'react';
+import Link from 'next/link';
+import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card';
+import { authAPI } from '@/lib/api';
+import { BookOpen } from 'lucide-react';
+
+export default function ResetPassword() {
+  // Emailed links carry the token as ?token=...; without one the page asks
+  // for an email to send a link to
+  const [token, setToken] = useState('');
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState('');
+  const [message, setMessage] = useState('');
+  const [done, setDone] = useState(false);
+
+  useEffect(() => {
+    setToken(new URLSearchParams(window.location.search).get('token') || '');
+  }, []);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+
+    if (token) {
+      if (password !== confirmPassword) {
+        setError('Passwords do not match');
+        return;
+      }
+      if (password.length < 6) {
+        setError('Password must be at least 6 characters long');
+        return;
+      }
+    }
+
+    setLoading(true);
+    try {
+      if (token) {
+        await authAPI.resetPassword(token, password);
+        setDone(true);
+      } else {
+        await authAPI.forgotPassword(email);
+        setMessage('If an account exists for that email, a reset link has been sent. Check your inbox.');
+      }
+    } catch (err: unknown) {
+      const error = err as { response?: { data?: { error?: string } } };
+      setError(error.response?.data?.error || 'Something went wrong. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 dark:bg-gray-900 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-8">
+        {/* Header */}
+        <div className="text-center">
+          <BookOpen className="mx-auto h-12 w-12 text-blue-600" />
+          <h2 className="mt-6 text-3xl font-bold text-gray-900 dark:text-white">
+            Reset your password
+          </h2>
+        </div>
+
+        <Card>
+          <CardHeader>
+            <CardTitle className="text-center">
+              {token ? 'Choose a new password' : 'Forgot your password?'}
+            </CardTitle>
+            <CardDescription className="text-center">
+              {token
+                ? 'You will be signed out everywhere once it is changed'
+                : "Enter your email and we'll send you a link to reset it"}
+            </CardDescription>
+          </CardHeader>
+          <CardContent>
+            {done ? (
+              <div className="space-y-6">
+                <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded-md">
+                  Your password has been reset. Sign in with your new password.
+                </div>
+                <Link href="/login">
+                  <Button className="w-full">Sign in</Button>
+                </Link>
+              </div>
+            ) : (
+              <form onSubmit={handleSubmit} className="space-y-6">
+                {error && (
+                  <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-md">
+                    {error}
+                  </div>
+                )}
+                {message && (
+                  <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded-md">
+                    {message}
+                  </div>
+                )}
+
+                {token ? (
+                  <>
+                    <div>
+                      <label htmlFor="password" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                        New Password
+                      </label>
+                      <Input
+                        id="password"
+                        name="password"
+                        type="password"
+                        autoComplete="new-password"
+                        required
+                        value={password}
+                        onChange={(e) => setPassword(e.target.value)}
+                        placeholder="Enter a new password"
+                        className="w-full"
+                      />
+                    </div>
+                    <div>
+                      <label htmlFor="confirmPassword" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                        Confirm Password
+                      </label>
+                      <Input
+                        id="confirmPassword"
+                        name="confirmPassword"
+                        type="password"
+                        autoComplete="new-password"
+                        required
+                        value={confirmPassword}
+                        onChange={(e) => setConfirmPassword(e.target.value)}
+                        placeholder="Confirm your new password"
+                        className="w-full"
+                      />
+                    </div>
+                  </>
+                ) : (
+                  <div>
+                    <label htmlFor="email" className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
+                      Email
+                    </label>
+                    <Input
+                      id="email"
+                      name="email"
+                      type="email"
+                      autoComplete="email"
+                      required
+                      value={email}
+                      onChange={(e) => setEmail(e.target.value)}
+                      placeholder="Enter your email"
+                      className="w-full"
+                    />
+                  </div>
+                )}
+
+                <Button type="submit" disabled={loading} className="w-full">
+                  {loading ? 'Please wait...' : token ? 'Reset password' : 'Send reset link'}
+                </Button>
+
+                <div className="text-center text-sm">
+                  <Link href="/login" className="text-blue-600 hover:underline">
+                    Back to sign in
+                  </Link>
+                </div>
+              </form>
+            )}
+          </CardContent>
+        </Card>
+      </div>
+    </div>
+  );
+}
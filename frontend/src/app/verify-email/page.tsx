@@ -0,0 +1,124 @@
+'use client';
+
+import { useEffect, useRef, useState } from 'react';
+import Link from 'next/link';
+import { Button } from '@/components/ui/button';
+import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card';
+import { authAPI } from '@/lib/api';
+import { BookOpen } from 'lucide-react';
+
+type Status = 'verifying' | 'verified' | 'failed';
+
+export default function VerifyEmail() {
+  const [status, setStatus] = useState<Status>('verifying');
+  const [error, setError] = useState('');
+  const [loggedIn, setLoggedIn] = useState(false);
+  const [resent, setResent] = useState(false);
+  const [resending, setResending] = useState(false);
+  const handled = useRef(false);
+
+  useEffect(() => {
+    // The token is checked once; effects can run twice in development
+    if (handled.current) return;
+    handled.current = true;
+    setLoggedIn(!!localStorage.getItem('token'));
+
+    const token = new URLSearchParams(window.location.search).get('token');
+    if (!token) {
+      setStatus('failed');
+      setError('This verification link is incomplete.');
+      return;
+    }
+
+    authAPI.verifyEmail(token)
+      .then((user) => {
+        // Keep a signed-in user's stored profile in step
+        const stored = localStorage.getItem('user');
+        if (stored && JSON.parse(stored).id === user.id) {
+          localStorage.setItem('user', JSON.stringify(user));
+        }
+        setStatus('verified');
+      })
+      .catch((err: unknown) => {
+        const error = err as { response?: { data?: { error?: string } } };
+        setError(error.response?.data?.error || 'Verification failed. Please try again.');
+        setStatus('failed');
+      });
+  }, []);
+
+  const handleResend = async () => {
+    setResending(true);
+    try {
+      await authAPI.resendVerification();
+      setResent(true);
+    } catch (err: unknown) {
+      const error = err as { response?: { data?: { error?: string } } };
+      setError(error.response?.data?.error || 'Failed to send a new link.');
+    } finally {
+      setResending(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 dark:bg-gray-900 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-8">
+        {/* Header */}
+        <div className="text-center">
+          <BookOpen className="mx-auto h-12 w-12 text-blue-600" />
+          <h2 className="mt-6 text-3xl font-bold text-gray-900 dark:text-white">
+            Verify your email
+          </h2>
+        </div>
+
+        <Card>
+          <CardHeader>
+            <CardTitle className="text-center">
+              {status === 'verifying' ? 'Verifying...' : status === 'verified' ? 'Email verified' : 'Verification failed'}
+            </CardTitle>
+          </CardHeader>
+          <CardContent className="space-y-6">
+            {status === 'verifying' && (
+              <div className="flex justify-center">
+                <div className="animate-spin rounded-full h-8 w-8 border-b-2 border-blue-600"></div>
+              </div>
+            )}
+
+            {status === 'verified' && (
+              <>
+                <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded-md">
+                  Thanks! You can now submit quests and appear on the leaderboard.
+                </div>
+                <Link href={loggedIn ? '/' : '/login'}>
+                  <Button className="w-full">{loggedIn ? 'Continue' : 'Sign in'}</Button>
+                </Link>
+              </>
+            )}
+
+            {status === 'failed' && (
+              <>
+                <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded-md">
+                  {error}
+                </div>
+                {resent ? (
+                  <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded-md">
+                    A new verification link is on its way. Check your inbox.
+                  </div>
+                ) : loggedIn ? (
+                  <Button onClick={handleResend} disabled={resending} className="w-full">
+                    {resending ? 'Sending...' : 'Send a new link'}
+                  </Button>
+                ) : (
+                  <Link href="/login">
+                    <Button variant="outline" className="w-full">
+                      Sign in to get a new link
+                    </Button>
+                  </Link>
+                )}
+              </>
+            )}
+          </CardContent>
+        </Card>
+      </div>
+    </div>
+  );
+}
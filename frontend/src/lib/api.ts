@@ -25,15 +25,62 @@ api.interceptors.request.use(
   }
 );
 
+// Clear the stored session and send the user back to login
+const endSession = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('user');
+  window.location.href = '/login';
+};
+
+// Refreshes started while one is already running share its result, so
+// parallel requests don't race to rotate the same refresh token
+let pendingRefresh: Promise<string> | null = null;
+
+const refreshSession = (refreshToken: string): Promise<string> => {
+  if (!pendingRefresh) {
+    pendingRefresh = axios
+      .post<AuthResponse>(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+      .then(({ data }) => {
+        localStorage.setItem('token', data.token);
+        localStorage.setItem('refresh_token', data.refresh_token);
+        localStorage.setItem('user', JSON.stringify(data.user));
+        return data.token;
+      })
+      .catch((error) => {
+        // Another tab rotated the token first - use the one it stored
+        const latest = localStorage.getItem('refresh_token');
+        if (error.response?.status === 409 && latest && latest !== refreshToken) {
+          return localStorage.getItem('token') as string;
+        }
+        throw error;
+      })
+      .finally(() => {
+        pendingRefresh = null;
+      });
+  }
+  return pendingRefresh;
+};
+
 // Response interceptor to handle auth errors
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    if (error.response?.status === 401) {
-      // Token expired or invalid
-      localStorage.removeItem('token');
-      localStorage.removeItem('user');
-      window.location.href = '/login';
+  async (error) => {
+    const original = error.config;
+    if (error.response?.status === 401 && original && !original._retry) {
+      // Access token expired - try once to refresh it
+      const refreshToken = localStorage.getItem('refresh_token');
+      if (refreshToken && !original.url?.startsWith('/auth/')) {
+        original._retry = true;
+        try {
+          const token = await refreshSession(refreshToken);
+          original.headers.Authorization = `Bearer ${token}`;
+          return api(original);
+        } catch {
+          // Refresh token expired or revoked
+        }
+      }
+      endSession();
     }
     return Promise.reject(error);
   }
@@ -52,6 +99,7 @@ export interface User {
   role: string;
   is_active: boolean;
   last_login: string;
+  email_verified_at?: string;
   created_at: string;
   updated_at: string;
 }
@@ -71,10 +119,23 @@ export interface Quest {
   start_date?: string;
   end_date?: string;
   max_submissions: number;
+  max_retries: number;
+  progress?: QuestProgress;
   created_at: string;
   updated_at: string;
 }
 
+export type QuestStatus = 'not_started' | 'pending' | 'approved' | 'rejected';
+
+export interface QuestProgress {
+  status: QuestStatus;
+  submission_id: number | null;
+  submitted_at: string | null;
+  admin_notes?: string;
+  remaining_attempts: number | null;
+  remaining_retries: number | null;
+}
+
 export interface Submission {
   id: number;
   user_id: number;
@@ -82,7 +143,11 @@ export interface Submission {
   content: string;
   media_url: string;
   media_type: string;
-  status: 'pending' | 'approved' | 'rejected';
+  thumbnail_url?: string;
+  preview_url?: string;
+  status: 'pending' | 'approved' | 'rejected' | 'withdrawn';
+  previous_submission_id: number | null;
+  attempt: number;
   points_awarded: number;
   admin_notes: string;
   reviewed_at?: string;
@@ -94,6 +159,11 @@ export interface Submission {
   reviewed_by?: User;
 }
 
+export interface SubmissionsPage {
+  total: number;
+  submissions: Submission[];
+}
+
 export interface LeaderboardEntry {
   rank: number;
   user_id: number;
@@ -107,9 +177,27 @@ export interface LeaderboardEntry {
 
 export interface AuthResponse {
   token: string;
+  refresh_token: string;
   user: User;
 }
 
+// Returned by login instead of tokens when the account has two-factor authentication
+export interface TwoFactorChallenge {
+  two_factor_required: true;
+  two_factor_token: string;
+}
+
+export interface RegistrationPolicy {
+  invite_required: boolean;
+  allowed_email_domains: string[];
+}
+
+export interface OIDCProvider {
+  name: string;
+  display_name: string;
+  login_url: string;
+}
+
 // Auth API functions
 export const authAPI = {
   register: async (userData: {
@@ -118,18 +206,65 @@ export const authAPI = {
     password: string;
     first_name: string;
     last_name: string;
+    invite_code?: string;
   }): Promise<AuthResponse> => {
     const response = await api.post('/auth/register', userData);
     return response.data;
   },
 
+  getRegistrationPolicy: async (): Promise<RegistrationPolicy> => {
+    const response = await api.get('/auth/registration');
+    return response.data;
+  },
+
   login: async (credentials: {
     username: string;
     password: string;
-  }): Promise<AuthResponse> => {
+  }): Promise<AuthResponse | TwoFactorChallenge> => {
     const response = await api.post('/auth/login', credentials);
     return response.data;
   },
+
+  verifyTwoFactor: async (data: {
+    two_factor_token: string;
+    code?: string;
+    recovery_code?: string;
+  }): Promise<AuthResponse> => {
+    const response = await api.post('/auth/2fa/verify', data);
+    return response.data;
+  },
+
+  forgotPassword: async (email: string): Promise<void> => {
+    await api.post('/auth/forgot-password', { email });
+  },
+
+  resetPassword: async (token: string, password: string): Promise<void> => {
+    await api.post('/auth/reset-password', { token, password });
+  },
+
+  verifyEmail: async (token: string): Promise<User> => {
+    const response = await api.post('/auth/verify-email', { token });
+    return response.data;
+  },
+
+  resendVerification: async (): Promise<void> => {
+    await api.post('/auth/resend-verification');
+  },
+
+  logout: async (allDevices = false): Promise<void> => {
+    await api.post(allDevices ? '/auth/logout-all' : '/auth/logout');
+  },
+
+  getOIDCProviders: async (): Promise<OIDCProvider[]> => {
+    const response = await api.get('/auth/oidc/providers');
+    return response.data;
+  },
+
+  // Single sign-on is a full-page redirect, so this is a URL rather than a request
+  oidcLoginURL: (provider: string, inviteCode?: string): string => {
+    const url = `${API_BASE_URL}/auth/oidc/${encodeURIComponent(provider)}/login`;
+    return inviteCode ? `${url}?invite_code=${encodeURIComponent(inviteCode)}` : url;
+  },
 };
 
 // User API functions
@@ -152,10 +287,11 @@ export const userAPI = {
 
 // Quest API functions
 export const questAPI = {
-  getQuests: async (filters?: { type?: string; difficulty?: string }): Promise<Quest[]> => {
+  getQuests: async (filters?: { type?: string; difficulty?: string; status?: QuestStatus[] }): Promise<Quest[]> => {
     const params = new URLSearchParams();
     if (filters?.type) params.append('type', filters.type);
     if (filters?.difficulty) params.append('difficulty', filters.difficulty);
+    if (filters?.status?.length) params.append('status', filters.status.join(','));
     
     const response = await api.get(`/quests?${params.toString()}`);
     return response.data;
@@ -170,6 +306,7 @@ export const questAPI = {
     content: string;
     media_url?: string;
     media_type?: string;
+    previous_submission_id?: number;
   }): Promise<Submission> => {
     const response = await api.post(`/quests/${questId}/submit`, submission);
     return response.data;
@@ -216,6 +353,46 @@ export const submissionAPI = {
     const response = await api.put(`/submissions/${id}/reject`, { admin_notes: adminNotes });
     return response.data;
   },
+
+  getMySubmissions: async (filters?: {
+    status?: string;
+    quest_id?: number;
+    from?: string;
+    to?: string;
+    limit?: number;
+    offset?: number;
+  }): Promise<SubmissionsPage> => {
+    const params = new URLSearchParams();
+    if (filters?.status) params.append('status', filters.status);
+    if (filters?.quest_id) params.append('quest_id', filters.quest_id.toString());
+    if (filters?.from) params.append('from', filters.from);
+    if (filters?.to) params.append('to', filters.to);
+    if (filters?.limit) params.append('limit', filters.limit.toString());
+    if (filters?.offset) params.append('offset', filters.offset.toString());
+
+    const response = await api.get(`/profile/submissions?${params.toString()}`);
+    return response.data;
+  },
+
+  getSubmission: async (id: number): Promise<Submission> => {
+    const response = await api.get(`/submissions/${id}`);
+    return response.data;
+  },
+
+  getSubmissionHistory: async (id: number): Promise<Submission[]> => {
+    const response = await api.get(`/submissions/${id}/history`);
+    return response.data;
+  },
+
+  updateSubmission: async (id: number, changes: { content: string; media_url?: string }): Promise<Submission> => {
+    const response = await api.put(`/submissions/${id}`, changes);
+    return response.data;
+  },
+
+  withdrawSubmission: async (id: number): Promise<Submission> => {
+    const response = await api.put(`/submissions/${id}/withdraw`);
+    return response.data;
+  },
 };
 
 // Leaderboard API functions